@@ -10,8 +10,8 @@ import (
 	"os"
 	"strings"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/protosam/pgstar/cli/customerrors"
+	"github.com/protosam/pgstar/cli/pgflags"
 	"github.com/protosam/pgstar/router"
 	"github.com/urfave/cli/v2"
 )
@@ -19,7 +19,7 @@ import (
 var Command = &cli.Command{
 	Name:  "exec",
 	Usage: "Run a script with an optional configuration file",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "json-data",
 			Usage: "JSON Encoded data to be passed in request",
@@ -38,7 +38,17 @@ var Command = &cli.Command{
 			EnvVars:  []string{"PGSTAR_POSTGRES_CONFIG"},
 			Required: true,
 		},
-	},
+		&cli.StringFlag{
+			Name:    "postgres-replica-config",
+			Usage:   "Connection string for a read replica used by read-only routes",
+			EnvVars: []string{"PGSTAR_POSTGRES_REPLICA_CONFIG"},
+		},
+		&cli.DurationFlag{
+			Name:    "query-timeout",
+			Usage:   "Default timeout for each database query, routes can override this (0 disables)",
+			EnvVars: []string{"PGSTAR_QUERY_TIMEOUT"},
+		},
+	}, pgflags.PoolFlags...),
 	Action: main,
 }
 
@@ -52,7 +62,10 @@ func main(c *cli.Context) error {
 	}
 
 	PGSTAR_POSTGRES_CONFIG := c.String("postgres-config")
+	PGSTAR_POSTGRES_REPLICA_CONFIG := c.String("postgres-replica-config")
 	noPrint := c.Bool("no-print")
+	queryTimeout := c.Duration("query-timeout")
+	acquireTimeout := c.Duration("postgres-acquire-timeout")
 	jsonDataStr := c.String("json-data")
 	headers := c.StringSlice("header")
 	starfile := c.Args().Get(0)
@@ -97,7 +110,7 @@ func main(c *cli.Context) error {
 	}
 
 	// Postgres connection pool setup.
-	dbpool, err := pgxpool.New(context.Background(), PGSTAR_POSTGRES_CONFIG)
+	dbpool, err := pgflags.NewPool(c, PGSTAR_POSTGRES_CONFIG)
 	if err != nil {
 		return fmt.Errorf("unable to create connection pool: %v", err)
 	}
@@ -106,11 +119,24 @@ func main(c *cli.Context) error {
 	// ensure dbpool is passed to router
 	router.SetDBPool(dbpool)
 
+	// databases added with addDatabase() are tuned by the same flags
+	router.SetNamedPoolOpener(pgflags.PoolOpener(c))
+
 	// Ping the database to verify the connection
 	if err := dbpool.Ping(context.Background()); err != nil {
 		return fmt.Errorf("failed to ping database: %s", err)
 	}
 
+	// optional read replica for read-only routes
+	if PGSTAR_POSTGRES_REPLICA_CONFIG != "" {
+		replicapool, err := pgflags.NewPool(c, PGSTAR_POSTGRES_REPLICA_CONFIG)
+		if err != nil {
+			return fmt.Errorf("unable to create replica connection pool: %v", err)
+		}
+		defer replicapool.Close()
+		router.SetReplicaPool(context.Background(), replicapool)
+	}
+
 	// log print output to stderr instead of stdout
 	log.SetOutput(os.Stderr)
 
@@ -118,6 +144,12 @@ func main(c *cli.Context) error {
 	if noPrint {
 		opts = append(opts, router.WithNullPrinter())
 	}
+	if queryTimeout > 0 {
+		opts = append(opts, &router.WithQueryTimeout{Timeout: queryTimeout})
+	}
+	if acquireTimeout > 0 {
+		opts = append(opts, &router.WithAcquireTimeout{Timeout: acquireTimeout})
+	}
 
 	router, err := router.ConfigureAndBuildRouter(starfile, opts...)
 	if err != nil {
@@ -0,0 +1,97 @@
+package get
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/protosam/pgstar/packages"
+	"github.com/urfave/cli/v2"
+)
+
+var Command = &cli.Command{
+	Name:      "get",
+	Usage:     "Add, update or remove packages in pgstar.lock, without arguments every locked package is downloaded",
+	ArgsUsage: "[path[@version] ...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "Directory of the configuration file, where " + packages.LockfileName + " is kept",
+			Value: ".",
+		},
+		&cli.StringFlag{
+			Name:    "cache-dir",
+			Usage:   "Directory packages are downloaded to, defaults to pgstar in the user cache directory",
+			EnvVars: []string{"PGSTAR_CACHE_DIR"},
+		},
+		&cli.StringFlag{
+			Name:  "url",
+			Usage: "Repository to clone instead of https://<path>.git, e.g. file:///srv/git/lib.git",
+		},
+	},
+	Action: main,
+}
+
+func main(c *cli.Context) error {
+	if c.String("url") != "" && c.Args().Len() != 1 {
+		return fmt.Errorf("--url can only be used with a single package")
+	}
+
+	cacheDir := c.String("cache-dir")
+	if cacheDir == "" {
+		var err error
+		if cacheDir, err = packages.DefaultCacheDir(); err != nil {
+			return err
+		}
+	}
+
+	dir := c.String("dir")
+	lock, err := packages.ReadLockfile(dir)
+	if err != nil {
+		return err
+	}
+	fetcher := &packages.Fetcher{CacheDir: cacheDir, Log: log.Printf}
+
+	// download what the lockfile pins, e.g. after cloning a project
+	if c.Args().Len() == 0 {
+		for _, pkg := range lock.Packages {
+			if err := fetcher.Install(c.Context, pkg); err != nil {
+				return err
+			}
+		}
+		log.Printf("%d packages installed", len(lock.Packages))
+		return nil
+	}
+
+	for _, arg := range c.Args().Slice() {
+		path, version, _ := strings.Cut(arg, "@")
+
+		// path@none removes a package from the lockfile
+		if version == "none" {
+			if !lock.Remove(path) {
+				return fmt.Errorf("%s is not in %s", path, packages.LockfileName)
+			}
+			log.Printf("removed %s", path)
+			continue
+		}
+
+		// the locked repository is reused unless another one is given
+		url := c.String("url")
+		if url == "" {
+			for _, pkg := range lock.Packages {
+				if pkg.Path == path {
+					url = pkg.URL
+				}
+			}
+		}
+
+		pkg, err := fetcher.Get(c.Context, path, version, url)
+		if err != nil {
+			return err
+		}
+		lock.Set(pkg)
+		log.Printf("locked %s@%s at %s", pkg.Path, pkg.Version, pkg.Commit)
+	}
+
+	return lock.Write(dir)
+}
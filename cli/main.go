@@ -6,6 +6,10 @@ import (
 
 	"github.com/protosam/pgstar/cli/customerrors"
 	"github.com/protosam/pgstar/cli/exec"
+	"github.com/protosam/pgstar/cli/get"
+	"github.com/protosam/pgstar/cli/migrate"
+	"github.com/protosam/pgstar/cli/openapi"
+	"github.com/protosam/pgstar/cli/schema"
 	"github.com/protosam/pgstar/cli/server"
 	"github.com/urfave/cli/v2"
 )
@@ -16,6 +20,10 @@ var App = &cli.App{
 	Commands: []*cli.Command{
 		server.Command,
 		exec.Command,
+		migrate.Command,
+		schema.Command,
+		openapi.Command,
+		get.Command,
 	},
 }
 
@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/protosam/pgstar/migrations"
+	"github.com/urfave/cli/v2"
+)
+
+var dirFlag = &cli.StringFlag{
+	Name:    "dir",
+	Usage:   "Directory containing the migration files",
+	EnvVars: []string{"PGSTAR_MIGRATIONS_DIR"},
+	Value:   "migrations",
+}
+
+var databaseFlags = []cli.Flag{
+	dirFlag,
+	&cli.StringFlag{
+		Name:     "postgres-config",
+		Usage:    "Connection string for postgres connection",
+		EnvVars:  []string{"PGSTAR_POSTGRES_CONFIG"},
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:    "table",
+		Usage:   "Table recording the applied migrations",
+		EnvVars: []string{"PGSTAR_MIGRATIONS_TABLE"},
+		Value:   migrations.DefaultTable,
+	},
+}
+
+var Command = &cli.Command{
+	Name:  "migrate",
+	Usage: "Manage the database schema with plain sql migration files",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "up",
+			Usage: "Apply pending migrations",
+			Flags: append([]cli.Flag{
+				&cli.IntFlag{
+					Name:  "steps",
+					Usage: "Number of pending migrations to apply (0 applies all)",
+				},
+			}, databaseFlags...),
+			Action: up,
+		},
+		{
+			Name:  "down",
+			Usage: "Revert applied migrations",
+			Flags: append([]cli.Flag{
+				&cli.IntFlag{
+					Name:  "steps",
+					Usage: "Number of applied migrations to revert",
+					Value: 1,
+				},
+			}, databaseFlags...),
+			Action: down,
+		},
+		{
+			Name:   "status",
+			Usage:  "Show applied and pending migrations",
+			Flags:  databaseFlags,
+			Action: status,
+		},
+		{
+			Name:      "create",
+			Usage:     "Create empty up and down files for a new migration",
+			ArgsUsage: "name",
+			Flags:     []cli.Flag{dirFlag},
+			Action:    create,
+		},
+	},
+}
+
+// migrator connects to postgres and loads the migration directory
+func migrator(c *cli.Context) (*migrations.Migrator, []*migrations.Migration, error) {
+	list, err := migrations.Load(c.String("dir"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load migrations: %w", err)
+	}
+
+	conn, err := pgx.Connect(c.Context, c.String("postgres-config"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to postgres: %w", err)
+	}
+
+	return &migrations.Migrator{Conn: conn, Table: c.String("table"), Log: log.Printf}, list, nil
+}
+
+func up(c *cli.Context) error {
+	m, list, err := migrator(c)
+	if err != nil {
+		return err
+	}
+	defer m.Conn.Close(context.Background())
+
+	count, err := m.Up(c.Context, list, c.Int("steps"))
+	if err != nil {
+		return err
+	}
+	log.Printf("%d migrations applied", count)
+	return nil
+}
+
+func down(c *cli.Context) error {
+	if c.Int("steps") < 1 {
+		return fmt.Errorf("steps must be at least 1")
+	}
+
+	m, list, err := migrator(c)
+	if err != nil {
+		return err
+	}
+	defer m.Conn.Close(context.Background())
+
+	count, err := m.Down(c.Context, list, c.Int("steps"))
+	if err != nil {
+		return err
+	}
+	log.Printf("%d migrations reverted", count)
+	return nil
+}
+
+func status(c *cli.Context) error {
+	m, list, err := migrator(c)
+	if err != nil {
+		return err
+	}
+	defer m.Conn.Close(context.Background())
+
+	statuses, err := m.Status(c.Context, list)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := ""
+		if !s.AppliedAt.IsZero() {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, appliedAt)
+	}
+	return w.Flush()
+}
+
+func create(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("you must provide a name for the migration")
+	}
+
+	upFile, downFile, err := migrations.Create(c.String("dir"), c.Args().Get(0), time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("created %s", upFile)
+	log.Printf("created %s", downFile)
+	return nil
+}
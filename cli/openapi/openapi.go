@@ -0,0 +1,70 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/protosam/pgstar/cli/pgflags"
+	"github.com/protosam/pgstar/router"
+	"github.com/urfave/cli/v2"
+)
+
+var Command = &cli.Command{
+	Name:      "openapi",
+	Usage:     "Write the OpenAPI document generated from a configuration file",
+	ArgsUsage: "config.star",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "File to write the document to, defaults to standard output",
+		},
+		&cli.StringFlag{
+			Name:    "postgres-config",
+			Usage:   "Connection string for postgres connection, needed by addQuery() and addResource()",
+			EnvVars: []string{"PGSTAR_POSTGRES_CONFIG"},
+		},
+	}, pgflags.PoolFlags...),
+	Action: main,
+}
+
+func main(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("you must provide a path to the configuration file")
+	}
+
+	// databases added with addDatabase() are tuned by the same flags
+	router.SetNamedPoolOpener(pgflags.PoolOpener(c))
+
+	// configurations that introspect the database need a connection
+	if dsn := c.String("postgres-config"); dsn != "" {
+		dbpool, err := pgflags.NewPool(c, dsn)
+		if err != nil {
+			return fmt.Errorf("unable to create connection pool: %v", err)
+		}
+		defer dbpool.Close()
+		if err := dbpool.Ping(context.Background()); err != nil {
+			return fmt.Errorf("failed to ping database: %s", err)
+		}
+		router.SetDBPool(dbpool)
+	}
+
+	cfg, err := router.Configure(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg.OpenAPI(), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if output := c.String("output"); output != "" {
+		return os.WriteFile(output, data, 0644)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,84 @@
+package pgflags
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/urfave/cli/v2"
+)
+
+// PoolFlags tune the postgres connection pools, unset flags keep the pgxpool
+// defaults or the values given in the connection string
+var PoolFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:    "postgres-max-conns",
+		Usage:   "Maximum number of connections in the pool",
+		EnvVars: []string{"PGSTAR_POSTGRES_MAX_CONNS"},
+	},
+	&cli.IntFlag{
+		Name:    "postgres-min-conns",
+		Usage:   "Minimum number of connections kept open in the pool",
+		EnvVars: []string{"PGSTAR_POSTGRES_MIN_CONNS"},
+	},
+	&cli.DurationFlag{
+		Name:    "postgres-max-conn-lifetime",
+		Usage:   "Duration after which a connection is closed and replaced",
+		EnvVars: []string{"PGSTAR_POSTGRES_MAX_CONN_LIFETIME"},
+	},
+	&cli.DurationFlag{
+		Name:    "postgres-max-conn-idle-time",
+		Usage:   "Duration after which an idle connection is closed",
+		EnvVars: []string{"PGSTAR_POSTGRES_MAX_CONN_IDLE_TIME"},
+	},
+	&cli.DurationFlag{
+		Name:    "postgres-health-check-period",
+		Usage:   "How often idle connections are checked",
+		EnvVars: []string{"PGSTAR_POSTGRES_HEALTH_CHECK_PERIOD"},
+	},
+	&cli.DurationFlag{
+		Name:    "postgres-acquire-timeout",
+		Usage:   "How long a request waits for a connection before failing with 503 (0 waits until the request ends)",
+		EnvVars: []string{"PGSTAR_POSTGRES_ACQUIRE_TIMEOUT"},
+	},
+}
+
+// NewPool creates a connection pool for dsn tuned with PoolFlags
+func NewPool(c *cli.Context, dsn string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.IsSet("postgres-max-conns") {
+		config.MaxConns = int32(c.Int("postgres-max-conns"))
+	}
+	if c.IsSet("postgres-min-conns") {
+		config.MinConns = int32(c.Int("postgres-min-conns"))
+	}
+	if c.IsSet("postgres-max-conn-lifetime") {
+		config.MaxConnLifetime = c.Duration("postgres-max-conn-lifetime")
+	}
+	if c.IsSet("postgres-max-conn-idle-time") {
+		config.MaxConnIdleTime = c.Duration("postgres-max-conn-idle-time")
+	}
+	if c.IsSet("postgres-health-check-period") {
+		config.HealthCheckPeriod = c.Duration("postgres-health-check-period")
+	}
+
+	if config.MaxConns < 1 {
+		return nil, fmt.Errorf("postgres-max-conns must be at least 1")
+	}
+	if config.MinConns < 0 || config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("postgres-min-conns must be between 0 and postgres-max-conns")
+	}
+
+	return pgxpool.NewWithConfig(context.Background(), config)
+}
+
+// PoolOpener returns NewPool bound to c, used for the pools of named databases
+func PoolOpener(c *cli.Context) func(dsn string) (*pgxpool.Pool, error) {
+	return func(dsn string) (*pgxpool.Pool, error) {
+		return NewPool(c, dsn)
+	}
+}
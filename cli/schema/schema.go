@@ -0,0 +1,134 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/protosam/pgstar/cli/customerrors"
+	"github.com/protosam/pgstar/migrations"
+	"github.com/protosam/pgstar/schema"
+	"github.com/urfave/cli/v2"
+)
+
+var databaseFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "postgres-config",
+		Usage:    "Connection string for postgres connection",
+		EnvVars:  []string{"PGSTAR_POSTGRES_CONFIG"},
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:    "table",
+		Usage:   "Table recording the applied migrations, it is left out of snapshots",
+		EnvVars: []string{"PGSTAR_MIGRATIONS_TABLE"},
+		Value:   migrations.DefaultTable,
+	},
+}
+
+var Command = &cli.Command{
+	Name:  "schema",
+	Usage: "Snapshot the database schema and detect drift",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "dump",
+			Usage: "Write a normalized snapshot of the database schema",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "File to write the snapshot to, defaults to standard output",
+				},
+			}, databaseFlags...),
+			Action: dump,
+		},
+		{
+			Name:  "diff",
+			Usage: "Compare the database with a snapshot or the migration directory, exits with 1 on drift",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "snapshot",
+					Usage: "Snapshot written by pgstar schema dump",
+				},
+				&cli.StringFlag{
+					Name:  "migrations",
+					Usage: "Migration directory applied to a temporary database for comparison",
+				},
+			}, databaseFlags...),
+			Action: diff,
+		},
+	},
+}
+
+func dump(c *cli.Context) error {
+	conn, err := pgx.Connect(c.Context, c.String("postgres-config"))
+	if err != nil {
+		return fmt.Errorf("unable to connect to postgres: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	snapshot, err := schema.Dump(c.Context, conn, c.String("table"))
+	if err != nil {
+		return err
+	}
+	data, err := snapshot.Encode()
+	if err != nil {
+		return err
+	}
+
+	if output := c.String("output"); output != "" {
+		return os.WriteFile(output, data, 0644)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func diff(c *cli.Context) error {
+	snapshotFile, migrationsDir := c.String("snapshot"), c.String("migrations")
+	if (snapshotFile == "") == (migrationsDir == "") {
+		return fmt.Errorf("exactly one of --snapshot or --migrations must be provided")
+	}
+
+	conn, err := pgx.Connect(c.Context, c.String("postgres-config"))
+	if err != nil {
+		return fmt.Errorf("unable to connect to postgres: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	var expected *schema.Snapshot
+	if snapshotFile != "" {
+		data, err := os.ReadFile(snapshotFile)
+		if err != nil {
+			return err
+		}
+		if expected, err = schema.Decode(data); err != nil {
+			return fmt.Errorf("unable to read snapshot %s: %w", snapshotFile, err)
+		}
+	} else {
+		list, err := migrations.Load(migrationsDir)
+		if err != nil {
+			return fmt.Errorf("unable to load migrations: %w", err)
+		}
+		if expected, err = schema.FromMigrations(c.Context, conn, list, c.String("table")); err != nil {
+			return err
+		}
+	}
+
+	actual, err := schema.Dump(c.Context, conn, c.String("table"))
+	if err != nil {
+		return err
+	}
+
+	drift := schema.Diff(expected, actual)
+	if len(drift) == 0 {
+		log.Printf("no schema drift found")
+		return nil
+	}
+
+	for _, d := range drift {
+		fmt.Println(d)
+	}
+	return &customerrors.ExitWithCode{Code: 1, Message: fmt.Sprintf("%d schema differences found", len(drift))}
+}
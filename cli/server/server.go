@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/protosam/pgstar/cli/pgflags"
 	"github.com/protosam/pgstar/router"
 	"github.com/urfave/cli/v2"
 )
@@ -15,7 +17,7 @@ import (
 var Command = &cli.Command{
 	Name:  "server",
 	Usage: "Start a server with the specified configuration file",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:    "bind-addr",
 			Usage:   "Path to the optional configuration file",
@@ -28,6 +30,22 @@ var Command = &cli.Command{
 			EnvVars:  []string{"PGSTAR_POSTGRES_CONFIG"},
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name:    "postgres-replica-config",
+			Usage:   "Connection string for a read replica used by read-only routes",
+			EnvVars: []string{"PGSTAR_POSTGRES_REPLICA_CONFIG"},
+		},
+		&cli.DurationFlag{
+			Name:    "query-timeout",
+			Usage:   "Default timeout for each database query, routes can override this (0 disables)",
+			EnvVars: []string{"PGSTAR_QUERY_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "postgres-stats-interval",
+			Usage:   "How often connection pool statistics are logged (0 disables)",
+			EnvVars: []string{"PGSTAR_POSTGRES_STATS_INTERVAL"},
+			Value:   5 * time.Minute,
+		},
 		&cli.StringFlag{
 			Name:    "ssl-cert",
 			Usage:   "Certificate for enabling SSL",
@@ -38,7 +56,7 @@ var Command = &cli.Command{
 			Usage:   "Private key to enable SSL",
 			EnvVars: []string{"PGSTAR_SSL_PRIVATE_KEY"},
 		},
-	},
+	}, pgflags.PoolFlags...),
 	Action: main,
 }
 
@@ -46,18 +64,35 @@ var server = &http.Server{
 	Handler: nil,
 }
 
+// options passed to the router every time the configuration is loaded
+var routerOptions []router.WithOption
+
+// stops the listeners started by the previously loaded configuration
+var stopListeners context.CancelFunc = func() {}
+
 func main(c *cli.Context) error {
 	if c.Args().Len() != 1 {
 		return fmt.Errorf("you must provide a path to the configuration file")
 	}
 	server.Addr = c.String("bind-addr")
 	PGSTAR_POSTGRES_CONFIG := c.String("postgres-config")
+	PGSTAR_POSTGRES_REPLICA_CONFIG := c.String("postgres-replica-config")
 	PGSTAR_SSL_CERTIFICATE := c.String("ssl-cert")
 	PGSTAR_SSL_PRIVATE_KEY := c.String("ssl-key")
+	PGSTAR_QUERY_TIMEOUT := c.Duration("query-timeout")
+	PGSTAR_POSTGRES_ACQUIRE_TIMEOUT := c.Duration("postgres-acquire-timeout")
+	PGSTAR_POSTGRES_STATS_INTERVAL := c.Duration("postgres-stats-interval")
 	starfile := c.Args().Get(0)
 
+	if PGSTAR_QUERY_TIMEOUT > 0 {
+		routerOptions = append(routerOptions, &router.WithQueryTimeout{Timeout: PGSTAR_QUERY_TIMEOUT})
+	}
+	if PGSTAR_POSTGRES_ACQUIRE_TIMEOUT > 0 {
+		routerOptions = append(routerOptions, &router.WithAcquireTimeout{Timeout: PGSTAR_POSTGRES_ACQUIRE_TIMEOUT})
+	}
+
 	// Postgres connection pool setup.
-	dbpool, err := pgxpool.New(context.Background(), PGSTAR_POSTGRES_CONFIG)
+	dbpool, err := pgflags.NewPool(c, PGSTAR_POSTGRES_CONFIG)
 	if err != nil {
 		return fmt.Errorf("unable to create connection pool: %v", err)
 	}
@@ -66,13 +101,35 @@ func main(c *cli.Context) error {
 	// ensure dbpool is passed to router
 	router.SetDBPool(dbpool)
 
+	// databases added with addDatabase() are tuned by the same flags
+	router.SetNamedPoolOpener(pgflags.PoolOpener(c))
+
 	// Ping the database to verify the connection
 	if err := dbpool.Ping(context.Background()); err != nil {
 		return fmt.Errorf("failed to ping database: %s", err)
 	}
 
+	if PGSTAR_POSTGRES_STATS_INTERVAL > 0 {
+		go logPoolStats("primary", dbpool, PGSTAR_POSTGRES_STATS_INTERVAL)
+	}
+
+	// optional read replica for read-only routes
+	if PGSTAR_POSTGRES_REPLICA_CONFIG != "" {
+		replicapool, err := pgflags.NewPool(c, PGSTAR_POSTGRES_REPLICA_CONFIG)
+		if err != nil {
+			return fmt.Errorf("unable to create replica connection pool: %v", err)
+		}
+		defer replicapool.Close()
+		router.SetReplicaPool(context.Background(), replicapool)
+		if PGSTAR_POSTGRES_STATS_INTERVAL > 0 {
+			go logPoolStats("replica", replicapool, PGSTAR_POSTGRES_STATS_INTERVAL)
+		}
+	}
+
 	// load initial configuration
-	loadConfig(starfile)
+	if err := loadConfig(starfile); err != nil {
+		return err
+	}
 
 	// autoreloading for config changes
 	go configReloader(starfile)
@@ -90,14 +147,35 @@ func main(c *cli.Context) error {
 	return nil
 }
 
-func loadConfig(starfile string) {
+// logPoolStats periodically logs the state of a connection pool
+func logPoolStats(name string, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stat := pool.Stat()
+		log.Printf("postgres %s pool: total=%d acquired=%d idle=%d constructing=%d max=%d acquires=%d empty_acquires=%d canceled_acquires=%d acquire_duration=%s",
+			name, stat.TotalConns(), stat.AcquiredConns(), stat.IdleConns(), stat.ConstructingConns(), stat.MaxConns(),
+			stat.AcquireCount(), stat.EmptyAcquireCount(), stat.CanceledAcquireCount(), stat.AcquireDuration())
+	}
+}
+
+func loadConfig(starfile string) error {
 	log.Printf("loading configuration: %s", starfile)
-	router, err := router.ConfigureAndBuildRouter(starfile)
+	cfg, err := router.Configure(starfile, routerOptions...)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	server.Handler = router
+	server.Handler = cfg.BuildRouter()
+	cfg.UsePools()
+
+	// restart listeners with the new configuration
+	stopListeners()
+	var ctx context.Context
+	ctx, stopListeners = context.WithCancel(context.Background())
+	cfg.StartListeners(ctx)
 	log.Printf("configuration reloaded successfully: %s", starfile)
+	return nil
 }
 
 func configReloader(starfile string) {
@@ -125,7 +203,10 @@ func configReloader(starfile string) {
 			}
 			if event.Op&fsnotify.Write == fsnotify.Write {
 				log.Printf("configuration updated")
-				loadConfig(starfile)
+				// a broken configuration keeps the previous one running
+				if err := loadConfig(starfile); err != nil {
+					log.Printf("configuration reload failed, keeping previous configuration: %s", err)
+				}
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
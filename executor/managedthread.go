@@ -14,6 +14,7 @@ type ManagedThread struct {
 	rootdir      string
 	predeclared  starlark.StringDict
 	moduleLoader *ModuleLoader
+	source       []byte
 }
 
 var pwd string
@@ -44,8 +45,15 @@ func NewManagedThread(rootdir, starfile string) *ManagedThread {
 }
 
 func (mt *ManagedThread) NewChild(starfile string) *ManagedThread {
-	name, _ := filepath.Rel(pwd, filepath.Join(mt.rootdir, starfile))
+	return mt.NewChildIn(mt.rootdir, starfile)
+}
+
+// NewChildIn returns a child running starfile from rootdir, e.g. the cache
+// directory of a package, scripts it loads are relative to rootdir as well
+func (mt *ManagedThread) NewChildIn(rootdir, starfile string) *ManagedThread {
+	name, _ := filepath.Rel(pwd, filepath.Join(rootdir, starfile))
 	childLoader := mt.moduleLoader.NewChild(starfile)
+	childLoader.rootdir = rootdir
 	return &ManagedThread{
 		Thread: &starlark.Thread{
 			Name:  name,
@@ -53,7 +61,7 @@ func (mt *ManagedThread) NewChild(starfile string) *ManagedThread {
 			Load:  childLoader.Load,
 		},
 		starfile:     starfile,
-		rootdir:      mt.rootdir,
+		rootdir:      rootdir,
 		predeclared:  mt.predeclared,
 		moduleLoader: childLoader,
 	}
@@ -72,6 +80,15 @@ func (mt *ManagedThread) SetModuleLoader(loader *ModuleLoader) {
 	mt.Thread.Load = loader.Load
 }
 
+func (mt *ManagedThread) GetModuleLoader() *ModuleLoader {
+	return mt.moduleLoader
+}
+
+// SetSource runs src instead of reading the starfile, used for scripts built into pgstar
+func (mt *ManagedThread) SetSource(src []byte) {
+	mt.source = src
+}
+
 func (mt *ManagedThread) Predeclare(name string, value starlark.Value) {
 	mt.predeclared[name] = value
 }
@@ -81,5 +98,9 @@ func (mt *ManagedThread) Exec() (starlark.StringDict, error) {
 		GlobalReassign:  true,
 		TopLevelControl: true,
 	}
-	return starlark.ExecFileOptions(fileOptions, mt.Thread, mt.Name, nil, mt.predeclared)
+	var src any
+	if mt.source != nil {
+		src = mt.source
+	}
+	return starlark.ExecFileOptions(fileOptions, mt.Thread, mt.Name, src, mt.predeclared)
 }
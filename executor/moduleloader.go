@@ -7,11 +7,12 @@ import (
 	"reflect"
 
 	"github.com/protosam/pgstar/executor/modules"
+	"github.com/protosam/pgstar/packages"
 	"go.starlark.net/starlark"
 )
 
 var ErrStatePointerRequired = errors.New("state values must be pointers")
-var ErrStateNotFound = errors.New("state not found")
+var ErrStateNotFound = modules.ErrStateNotFound
 
 type ModuleLoader struct {
 	mt              *ManagedThread
@@ -19,6 +20,7 @@ type ModuleLoader struct {
 	loaded          []string
 	state           map[string]interface{}
 	localizedStates map[string]modules.LocalizedModule
+	packages        *packages.Resolver
 }
 
 // NewModuleLoader creates a root level ModuleLoader
@@ -41,22 +43,19 @@ func (loader *ModuleLoader) NewChild(module string) *ModuleLoader {
 		loaded:          append(loader.loaded, module),
 		state:           loader.state,
 		localizedStates: loader.localizedStates,
+		packages:        loader.packages,
 	}
 }
 
+// SetPackages enables loading files of the packages locked with pgstar get
+func (loader *ModuleLoader) SetPackages(resolver *packages.Resolver) {
+	loader.packages = resolver
+}
+
 // Load handles loading script modules
 func (loader *ModuleLoader) Load(thread *starlark.Thread, modulePath string) (starlark.StringDict, error) {
-	if _, ok := Modules[modulePath]; ok {
-		if _, ok := loader.localizedStates[modulePath]; !ok {
-			module, err := Modules[modulePath](loader)
-			loader.localizedStates[modulePath] = module
-			if err != nil {
-				return nil, err
-			}
-
-		}
-
-		return loader.localizedStates[modulePath].Exports(), nil
+	if _, ok := lookupModule(modulePath); ok {
+		return loader.LoadModule(modulePath)
 	}
 
 	// fallback to using another starlark script as the module
@@ -65,7 +64,38 @@ func (loader *ModuleLoader) Load(thread *starlark.Thread, modulePath string) (st
 			return nil, fmt.Errorf("recusive loading of module %s is not allowed", modulePath)
 		}
 	}
-	return loader.mt.NewChild(modulePath).Exec()
+
+	// files of packages are read from the cache, e.g. github.com/org/lib/path.star
+	if loader.packages != nil {
+		dir, file, ok, err := loader.packages.Resolve(modulePath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return loader.mt.NewChildIn(dir, file).Exec()
+		}
+	}
+
+	return loader.mt.NewChildIn(loader.rootdir, modulePath).Exec()
+}
+
+// LoadModule returns the exports of a builtin module, modules are constructed
+// once per script execution and shared by every load of the same path
+func (loader *ModuleLoader) LoadModule(modulePath string) (starlark.StringDict, error) {
+	constructor, ok := lookupModule(modulePath)
+	if !ok {
+		return nil, fmt.Errorf("module %s does not exist", modulePath)
+	}
+
+	if _, ok := loader.localizedStates[modulePath]; !ok {
+		module, err := constructor(loader)
+		if err != nil {
+			return nil, err
+		}
+		loader.localizedStates[modulePath] = module
+	}
+
+	return loader.localizedStates[modulePath].Exports(), nil
 }
 
 // SetState can be called by modules to update a state
@@ -110,10 +140,16 @@ func (loader *ModuleLoader) GetThreadName() string {
 	return loader.mt.Name
 }
 
-func (loader *ModuleLoader) Destroy() {
+// Destroy finalizes all loaded modules, execErr is the error returned from
+// executing the script and is used to tell modules how execution ended
+func (loader *ModuleLoader) Destroy(execErr error) error {
+	var errs []error
+	outcome := modules.NewOutcome(execErr)
 	for name := range loader.localizedStates {
-		if err := loader.localizedStates[name].Destroy(loader); err != nil {
+		if err := loader.localizedStates[name].Destroy(loader, outcome); err != nil {
 			log.Printf("%s: error destroying module %s: %s", loader.mt.Name, name, err)
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
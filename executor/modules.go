@@ -1,6 +1,8 @@
 package executor
 
 import (
+	"strings"
+
 	"github.com/protosam/pgstar/executor/modules"
 	"github.com/protosam/pgstar/executor/modules/crypto/modaes"
 	"github.com/protosam/pgstar/executor/modules/crypto/modecdsa"
@@ -12,15 +14,18 @@ import (
 	"github.com/protosam/pgstar/executor/modules/encoding/modjson"
 	"github.com/protosam/pgstar/executor/modules/encoding/modyaml"
 	"github.com/protosam/pgstar/executor/modules/modhttp"
+	"github.com/protosam/pgstar/executor/modules/modlistener"
 	"github.com/protosam/pgstar/executor/modules/modmath"
 	"github.com/protosam/pgstar/executor/modules/modpostgres"
 	"github.com/protosam/pgstar/executor/modules/modregex"
 	"github.com/protosam/pgstar/executor/modules/modtime"
+	"github.com/protosam/pgstar/executor/modules/modvalidate"
 )
 
 var Modules = map[string]modules.ModuleExporterFn{
 	"pgstar/postgres":        modpostgres.Constructor,
 	"pgstar/http":            modhttp.Constructor,
+	"pgstar/listener":        modlistener.Constructor,
 	"pgstar/math":            modmath.Constructor,
 	"pgstar/time":            modtime.Constructor,
 	"pgstar/regex":           modregex.Constructor,
@@ -33,4 +38,25 @@ var Modules = map[string]modules.ModuleExporterFn{
 	"pgstar/encoding/hex":    modhex.Constructor,
 	"pgstar/encoding/json":   modjson.Constructor,
 	"pgstar/encoding/yaml":   modyaml.Constructor,
+	"pgstar/validate":        modvalidate.Constructor,
+}
+
+// ModuleFactories build modules for load paths that start with a prefix,
+// e.g. pgstar/postgres/<name> for named databases
+var ModuleFactories = map[string]func(name string) modules.ModuleExporterFn{
+	"pgstar/postgres/": modpostgres.NamedConstructor,
+}
+
+func lookupModule(modulePath string) (modules.ModuleExporterFn, bool) {
+	if constructor, ok := Modules[modulePath]; ok {
+		return constructor, true
+	}
+
+	for prefix, factory := range ModuleFactories {
+		if name, ok := strings.CutPrefix(modulePath, prefix); ok && name != "" {
+			return factory(name), true
+		}
+	}
+
+	return nil, false
 }
@@ -33,7 +33,7 @@ func (module *Module) Exports() starlark.StringDict {
 	}
 }
 
-func (module *Module) Destroy(loader modules.ModuleLoader) error { return nil }
+func (module *Module) Destroy(loader modules.ModuleLoader, outcome modules.Outcome) error { return nil }
 
 func (module *Module) Name() string {
 	return ModuleName
@@ -51,6 +51,7 @@ func (module *Module) Exports() starlark.StringDict {
 				"query":      starlark.NewBuiltin("http.query", module.HTTPQueryFn),
 				"vars":       starlark.NewBuiltin("http.vars", module.HTTPVarsFn),
 				"write":      starlark.NewBuiltin("http.write", module.HTTPWriterFn),
+				"stream":     starlark.NewBuiltin("http.stream", module.HTTPStreamFn),
 				"setHeader":  starlark.NewBuiltin("http.setHeader", module.HTTPSetHeaderFn),
 				"location":   starlark.NewBuiltin("http.location", module.HTTPLocationFn),
 				"setCookie":  starlark.NewBuiltin("http.setCookie", module.HTTPSetCookieFn),
@@ -65,7 +66,7 @@ func (module *Module) Exports() starlark.StringDict {
 	}
 }
 
-func (module *Module) Destroy(loader modules.ModuleLoader) error { return nil }
+func (module *Module) Destroy(loader modules.ModuleLoader, outcome modules.Outcome) error { return nil }
 
 func (module *Module) Name() string {
 	return ModuleName
@@ -85,7 +86,7 @@ func (module *Module) HTTPWriterFn(thread *starlark.Thread, fn *starlark.Builtin
 		return starlark.None, err
 	}
 	module.w.Write([]byte(sljson))
-	return starlark.None, modules.ErrEarlyExit
+	return starlark.None, &modules.EarlyExit{StatusCode: statuscode}
 
 }
 
@@ -118,7 +119,7 @@ func (module *Module) HTTPLocationFn(thread *starlark.Thread, fn *starlark.Built
 
 	module.w.Header().Set("Location", destination)
 	module.w.WriteHeader(statuscode)
-	return starlark.None, modules.ErrEarlyExit
+	return starlark.None, &modules.EarlyExit{StatusCode: statuscode}
 
 }
 
@@ -0,0 +1,104 @@
+package modhttp
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+
+	"github.com/protosam/pgstar/executor/modules"
+	"github.com/protosam/pgstar/executor/modules/starutils"
+	"go.starlark.net/starlark"
+)
+
+// streamFlushSize is how many buffered bytes are sent to the client at a time
+const streamFlushSize = 32 * 1024
+
+// iteratorErr is implemented by iterators that can fail while fetching, such
+// as db.rows and db.cursor, a failed iteration must not look like a complete
+// response
+type iteratorErr interface {
+	Err() error
+}
+
+// HTTPStreamFn writes an iterable to the response as a JSON array or as
+// newline delimited JSON, one value at a time with chunked transfer encoding
+func (module *Module) HTTPStreamFn(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var statuscode int
+	var rows starlark.Iterable
+	format := "json"
+	var mapfn starlark.Callable
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "statuscode", &statuscode, "rows", &rows, "format?", &format, "fn?", &mapfn); err != nil {
+		return starlark.None, err
+	}
+
+	var open, separator, terminator, end string
+	switch format {
+	case "json":
+		open, separator, end = "[", ",", "]"
+		module.w.Header().Set("Content-Type", "application/json")
+	case "ndjson":
+		terminator = "\n"
+		module.w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		return starlark.None, fmt.Errorf("%s(): format must be json or ndjson, got %q", fn.Name(), format)
+	}
+
+	module.w.WriteHeader(statuscode)
+	flusher, _ := module.w.(http.Flusher)
+	bw := bufio.NewWriterSize(module.w, streamFlushSize)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	iter := rows.Iterate()
+	defer iter.Done()
+
+	bw.WriteString(open)
+	var row starlark.Value
+	for n := 0; iter.Next(&row); n++ {
+		if mapfn != nil {
+			mapped, err := starlark.Call(thread, mapfn, starlark.Tuple{row}, nil)
+			if err != nil {
+				return starlark.None, err
+			}
+			row = mapped
+		}
+
+		sljson, err := starutils.StarlarkJsonEncoder(row)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s(): row %d: %w", fn.Name(), n+1, err)
+		}
+		if n > 0 {
+			bw.WriteString(separator)
+		}
+		bw.WriteString(sljson)
+		bw.WriteString(terminator)
+
+		if bw.Buffered() >= streamFlushSize/2 {
+			if err := flush(); err != nil {
+				return starlark.None, fmt.Errorf("%s(): %w", fn.Name(), err)
+			}
+		}
+	}
+
+	// the response is left incomplete so the client can tell it was cut short
+	if it, ok := iter.(iteratorErr); ok {
+		if err := it.Err(); err != nil {
+			flush()
+			return starlark.None, fmt.Errorf("%s(): %w", fn.Name(), err)
+		}
+	}
+
+	bw.WriteString(end)
+	if err := flush(); err != nil {
+		return starlark.None, fmt.Errorf("%s(): %w", fn.Name(), err)
+	}
+
+	return starlark.None, &modules.EarlyExit{StatusCode: statuscode}
+}
@@ -0,0 +1,65 @@
+package modlistener
+
+import (
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/protosam/pgstar/executor/modules"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	ModuleName            = "listener"
+	StateNameNotification = "listener/notification"
+)
+
+type Module struct {
+	notification *pgconn.Notification
+}
+
+func Constructor(loader modules.ModuleLoader) (modules.LocalizedModule, error) {
+	var notification *pgconn.Notification
+	if err := loader.GetState(StateNameNotification, &notification); err != nil {
+		return nil, err
+	}
+	return &Module{notification: notification}, nil
+}
+
+func (module *Module) Exports() starlark.StringDict {
+	return starlark.StringDict{
+		"exports": starlarkstruct.FromStringDict(
+			starlark.String(ModuleName),
+			starlark.StringDict{
+				"channel": starlark.NewBuiltin("listener.channel", module.channel),
+				"payload": starlark.NewBuiltin("listener.payload", module.payload),
+				"pid":     starlark.NewBuiltin("listener.pid", module.pid),
+			},
+		),
+	}
+}
+
+func (module *Module) Destroy(loader modules.ModuleLoader, outcome modules.Outcome) error { return nil }
+
+func (module *Module) Name() string {
+	return ModuleName
+}
+
+func (module *Module) channel(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+	return starlark.String(module.notification.Channel), nil
+}
+
+func (module *Module) payload(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+	return starlark.String(module.notification.Payload), nil
+}
+
+func (module *Module) pid(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+	return starlark.MakeInt64(int64(module.notification.PID)), nil
+}
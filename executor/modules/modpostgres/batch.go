@@ -0,0 +1,173 @@
+package modpostgres
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+type batchStatement struct {
+	sql    string
+	params []interface{}
+}
+
+// batch sends statements to postgres in a single round trip. With savepoints
+// enabled a failed statement only rolls back its own work and the remaining
+// statements are sent again in a new batch.
+func (module *Module) batch(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var slstatements *starlark.List
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "statements", &slstatements); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	statements, err := parseBatchStatements(thread, fn, slstatements)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	ctx, cancel := module.statementContext()
+	defer cancel()
+
+	results := make([]starlark.Value, len(statements))
+	for start := 0; start < len(statements); {
+		batch := &pgx.Batch{}
+		for i := start; i < len(statements); i++ {
+			if module.autosavepoints {
+				batch.Queue("SAVEPOINT " + module.savepointname)
+			}
+			batch.Queue(statements[i].sql, statements[i].params...)
+			if module.autosavepoints {
+				batch.Queue("RELEASE SAVEPOINT " + module.savepointname)
+			}
+		}
+
+		failedAt := -1
+		batchResults := module.tx.SendBatch(ctx, batch)
+		for i := start; i < len(statements); i++ {
+			if module.autosavepoints {
+				if _, err := batchResults.Exec(); err != nil {
+					// this is an unrecoverable system error
+					batchResults.Close()
+					return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+				}
+			}
+
+			result, err := readBatchResult(batchResults)
+			if err != nil {
+				module.failed(err)
+				results[i] = starlark.Tuple{starlark.None, newError(err)}
+				failedAt = i
+				break
+			}
+			results[i] = starlark.Tuple{result, starlark.None}
+
+			if module.autosavepoints {
+				if _, err := batchResults.Exec(); err != nil {
+					batchResults.Close()
+					return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+				}
+			}
+		}
+		batchResults.Close()
+
+		if failedAt < 0 {
+			break
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return starlark.None, fmt.Errorf("%s(): %w", fn.Name(), ctxErr)
+		}
+
+		// without savepoints the transaction is aborted and nothing else can run
+		if !module.autosavepoints {
+			for i := failedAt + 1; i < len(statements); i++ {
+				results[i] = starlark.Tuple{starlark.None, newError(fmt.Errorf("statement was not executed because statement %d failed", failedAt+1))}
+			}
+			break
+		}
+
+		if _, err := module.tx.Exec(module.ctx, "ROLLBACK TO SAVEPOINT "+module.savepointname); err != nil {
+			// this is an unrecoverable system error
+			return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+		if _, err := module.tx.Exec(module.ctx, "RELEASE SAVEPOINT "+module.savepointname); err != nil {
+			return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+		module.txstate = txActive
+		start = failedAt + 1
+	}
+
+	return starlark.NewList(results), nil
+}
+
+// parseBatchStatements accepts sql strings or (sql, args) pairs
+func parseBatchStatements(thread *starlark.Thread, fn *starlark.Builtin, slstatements *starlark.List) ([]batchStatement, error) {
+	statements := make([]batchStatement, 0, slstatements.Len())
+	for i := 0; i < slstatements.Len(); i++ {
+		var sql string
+		var pgargs starlark.Value = starlark.NewList(nil)
+
+		switch item := slstatements.Index(i).(type) {
+		case starlark.String:
+			sql = string(item)
+		case starlark.Tuple, *starlark.List:
+			seq := item.(starlark.Indexable)
+			if seq.Len() < 1 || seq.Len() > 2 {
+				return nil, fmt.Errorf("%s(): statement %d must be (sql, args)", fn.Name(), i+1)
+			}
+			s, ok := starlark.AsString(seq.Index(0))
+			if !ok {
+				return nil, fmt.Errorf("%s(): statement %d sql must be a string", fn.Name(), i+1)
+			}
+			sql = s
+			if seq.Len() == 2 {
+				pgargs = seq.Index(1)
+			}
+		default:
+			return nil, fmt.Errorf("%s(): statement %d must be a string or (sql, args), got %s", fn.Name(), i+1, item.Type())
+		}
+
+		sql, params, err := bindArgs(thread, fn, sql, pgargs)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		statements = append(statements, batchStatement{sql: sql, params: params})
+	}
+
+	return statements, nil
+}
+
+// readBatchResult reads the next statement result of a batch
+func readBatchResult(batchResults pgx.BatchResults) (starlark.Value, error) {
+	rows, err := batchResults.Query()
+	if err != nil {
+		return starlark.None, err
+	}
+	defer rows.Close()
+
+	slrows := starlark.NewList(nil)
+	for rows.Next() {
+		row, err := parseRow(rows)
+		if err != nil {
+			return starlark.None, err
+		}
+		slrows.Append(row)
+	}
+	if err := rows.Err(); err != nil {
+		return starlark.None, err
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String("db.result"),
+		starlark.StringDict{
+			"rowsAffected": starlark.MakeInt64(rows.CommandTag().RowsAffected()),
+			"rows":         slrows,
+		},
+	), nil
+}
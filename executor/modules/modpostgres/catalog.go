@@ -0,0 +1,136 @@
+package modpostgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.starlark.net/starlark"
+)
+
+const StateNameQueries = "postgres/queries"
+
+// NamedQuery is a statement registered in the query catalog with addQuery(),
+// it is prepared when the configuration loads so mistakes fail early
+type NamedQuery struct {
+	Name   string
+	SQL    string
+	Params []QueryParam
+	Fields []QueryField
+
+	// statement is the name the query is prepared under on each connection,
+	// statementSQL is the sql with named placeholders rewritten
+	statement    string
+	statementSQL string
+}
+
+// QueryParam describes a parameter of a prepared statement, Name is only set
+// when the statement uses :name or @name placeholders
+type QueryParam struct {
+	Name string
+	Type string
+	OID  uint32
+}
+
+// QueryField describes a column returned by a prepared statement
+type QueryField struct {
+	Name string
+	Type string
+	OID  uint32
+}
+
+// Queries is the query catalog keyed by query name
+type Queries map[string]*NamedQuery
+
+// PrepareQuery prepares sql on the database and returns it with the parameter
+// and result metadata reported by postgres
+func PrepareQuery(ctx context.Context, pool *pgxpool.Pool, name, sql string) (*NamedQuery, error) {
+	// named placeholders are rewritten the same way db.query() does it
+	rewritten, names, err := rewriteNamedPlaceholders(sql, func(string, int) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	// the unnamed statement is replaced by the next one, nothing to clean up
+	sd, err := conn.Conn().PgConn().Prepare(ctx, "", rewritten, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typeNames := map[uint32]string{}
+	typeName := func(oid uint32) (string, error) {
+		if name, ok := typeNames[oid]; ok {
+			return name, nil
+		}
+		var name string
+		if err := conn.QueryRow(ctx, "SELECT format_type($1, NULL)", oid).Scan(&name); err != nil {
+			return "", err
+		}
+		typeNames[oid] = name
+		return name, nil
+	}
+
+	// the statement name changes with the sql, so a reload never clashes
+	// with a statement prepared by the previous configuration
+	query := &NamedQuery{
+		Name:         name,
+		SQL:          sql,
+		statement:    fmt.Sprintf("pgstar_query_%x", sha256.Sum256([]byte(rewritten)))[:45],
+		statementSQL: rewritten,
+	}
+	for i, oid := range sd.ParamOIDs {
+		typ, err := typeName(oid)
+		if err != nil {
+			return nil, err
+		}
+		param := QueryParam{Type: typ, OID: oid}
+		if i < len(names) {
+			param.Name = names[i]
+		}
+		query.Params = append(query.Params, param)
+	}
+	for _, field := range sd.Fields {
+		typ, err := typeName(field.DataTypeOID)
+		if err != nil {
+			return nil, err
+		}
+		query.Fields = append(query.Fields, QueryField{Name: field.Name, Type: typ, OID: field.DataTypeOID})
+	}
+
+	return query, nil
+}
+
+// named runs a query from the catalog, it returns rows like db.query()
+func (module *Module) named(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var pgargs starlark.Value = starlark.NewList(nil)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "args?", &pgargs); err != nil {
+		return starlark.None, err
+	}
+
+	query, ok := module.queries[name]
+	if !ok {
+		return starlark.None, fmt.Errorf("%s(): query %q is not in the catalog", fn.Name(), name)
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	_, params, err := bindArgs(thread, fn, query.SQL, pgargs)
+	if err != nil {
+		return starlark.None, err
+	}
+	if len(params) != len(query.Params) {
+		return starlark.None, fmt.Errorf("%s(): query %q takes %d arguments, got %d", fn.Name(), name, len(query.Params), len(params))
+	}
+
+	return module.runQuery(thread, fn, query.statement, query.statementSQL, params)
+}
@@ -0,0 +1,103 @@
+package modpostgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.starlark.net/starlark"
+)
+
+// copyFrom bulk loads rows into a table with COPY FROM inside the request transaction
+func (module *Module) copyFrom(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var table string
+	var slcolumns *starlark.List
+	var slrows starlark.Iterable
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "table", &table, "columns", &slcolumns, "rows", &slrows); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	columns := make([]string, 0, slcolumns.Len())
+	for i := 0; i < slcolumns.Len(); i++ {
+		column, ok := starlark.AsString(slcolumns.Index(i))
+		if !ok {
+			return starlark.None, fmt.Errorf("%s(): columns must be a list of strings", fn.Name())
+		}
+		columns = append(columns, column)
+	}
+
+	// rows are converted as they are sent so large iterables are not copied in memory
+	iter := slrows.Iterate()
+	defer iter.Done()
+	rownum := 0
+	var convErr error
+	source := pgx.CopyFromFunc(func() ([]any, error) {
+		var slrow starlark.Value
+		if !iter.Next(&slrow) {
+			return nil, nil
+		}
+		rownum++
+
+		values, ok := slrow.(starlark.Indexable)
+		if !ok {
+			convErr = fmt.Errorf("%s(): row %d must be a list or tuple, got %s", fn.Name(), rownum, slrow.Type())
+			return nil, convErr
+		}
+		if values.Len() != len(columns) {
+			convErr = fmt.Errorf("%s(): row %d has %d values, expected %d", fn.Name(), rownum, values.Len(), len(columns))
+			return nil, convErr
+		}
+
+		row := make([]any, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			value, err := starlarkToGoValue(values.Index(i))
+			if err != nil {
+				convErr = fmt.Errorf("%s(): row %d column %s: %w", fn.Name(), rownum, columns[i], err)
+				return nil, convErr
+			}
+			row[i] = value
+		}
+		return row, nil
+	})
+
+	if module.autosavepoints {
+		if _, err := module.tx.Exec(module.ctx, "SAVEPOINT "+module.savepointname); err != nil {
+			// this is an unrecoverable system error
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+	}
+
+	ctx, cancel := module.statementContext()
+	defer cancel()
+	copied, err := module.tx.CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, source)
+	if err != nil {
+		module.failed(err)
+		if convErr != nil {
+			return starlark.Tuple{starlark.None, starlark.None}, convErr
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %w", fn.Name(), ctxErr)
+		}
+		if module.autosavepoints {
+			if _, err := module.tx.Exec(module.ctx, "ROLLBACK TO SAVEPOINT "+module.savepointname); err != nil {
+				// this is an unrecoverable system error
+				return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+			}
+			module.txstate = txActive
+		}
+
+		return starlark.Tuple{starlark.None, newError(err)}, nil
+	}
+
+	if module.autosavepoints {
+		if _, err := module.tx.Exec(module.ctx, "RELEASE SAVEPOINT "+module.savepointname); err != nil {
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+	}
+
+	return starlark.Tuple{starlark.MakeInt64(copied), starlark.None}, nil
+}
@@ -0,0 +1,184 @@
+package modpostgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.starlark.net/starlark"
+)
+
+// defaultCursorFetchSize is the number of rows fetched per round trip by db.cursor()
+const defaultCursorFetchSize = 1000
+
+// Cursor reads a query through a server-side cursor, fetching rows in
+// batches so large results never have to be held in memory
+type Cursor struct {
+	module    *Module
+	name      string
+	fetchSize int
+	thread    *starlark.Thread
+	iterated  bool
+}
+
+func (c *Cursor) String() string        { return "db.cursor(" + c.name + ")" }
+func (c *Cursor) Type() string          { return "db.cursor" }
+func (c *Cursor) Freeze()               {}
+func (c *Cursor) Truth() starlark.Bool  { return true }
+func (c *Cursor) Hash() (uint32, error) { return 0, fmt.Errorf("db.cursor: unsupported operator") }
+
+func (c *Cursor) Iterate() starlark.Iterator {
+	it := &cursorIterator{cursor: c}
+	if c.iterated {
+		it.err = fmt.Errorf("db.cursor can only be iterated once")
+		it.done = true
+		it.closed = true
+	}
+	c.iterated = true
+	return it
+}
+
+type cursorIterator struct {
+	cursor *Cursor
+	buf    []*starlark.Dict
+	done   bool
+	closed bool
+	err    error
+}
+
+func (it *cursorIterator) Next(p *starlark.Value) bool {
+	if len(it.buf) == 0 && !it.done {
+		if err := it.fetch(); err != nil {
+			it.err = err
+			it.done = true
+			it.cursor.thread.Cancel(fmt.Sprintf("failed to read db cursor: %s", err))
+			return false
+		}
+	}
+	if len(it.buf) == 0 {
+		return false
+	}
+	*p = it.buf[0]
+	it.buf = it.buf[1:]
+	return true
+}
+
+// fetch reads the next batch of rows from the cursor
+func (it *cursorIterator) fetch() error {
+	module := it.cursor.module
+	if module.txstate != txActive {
+		return fmt.Errorf("transaction is no longer active")
+	}
+
+	ctx, cancel := module.statementContext()
+	defer cancel()
+	rows, err := module.tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM %s", it.cursor.fetchSize, it.cursor.name))
+	if err != nil {
+		module.failed(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row, err := parseRow(rows)
+		if err != nil {
+			return err
+		}
+		it.buf = append(it.buf, row)
+	}
+	if err := rows.Err(); err != nil {
+		module.failed(err)
+		return err
+	}
+
+	if len(it.buf) < it.cursor.fetchSize {
+		it.done = true
+	}
+	return nil
+}
+
+// Err reports an error that ended the iteration early
+func (it *cursorIterator) Err() error {
+	return it.err
+}
+
+func (it *cursorIterator) Done() {
+	if it.closed {
+		return
+	}
+	it.closed = true
+
+	module := it.cursor.module
+	if module.txstate != txActive {
+		return
+	}
+	if _, err := module.tx.Exec(module.ctx, "CLOSE "+it.cursor.name); err != nil {
+		it.cursor.thread.Cancel(fmt.Sprintf("failed to close db cursor: %s", err))
+	}
+}
+
+// cursor declares a server-side cursor for a query, rows are fetched in
+// batches of fetchSize while the cursor is iterated
+func (module *Module) cursor(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var sql string
+	var pgargs starlark.Value = starlark.NewList(nil)
+	fetchSize := defaultCursorFetchSize
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "sql", &sql, "args?", &pgargs, "fetchSize?", &fetchSize); err != nil {
+		return starlark.None, err
+	}
+	if fetchSize < 1 {
+		return starlark.None, fmt.Errorf("%s(): fetchSize must be at least 1", fn.Name())
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	sql, params, err := bindArgs(thread, fn, sql, pgargs)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	if module.autosavepoints {
+		if _, err := module.tx.Exec(module.ctx, "SAVEPOINT "+module.savepointname); err != nil {
+			// this is an unrecoverable system error
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+	}
+
+	name := "pgstar_cursor_" + strings.ReplaceAll(uuid.Must(uuid.NewRandom()).String(), "-", "")
+
+	ctx, cancel := module.statementContext()
+	defer cancel()
+	if _, err := module.tx.Exec(ctx, "DECLARE "+name+" NO SCROLL CURSOR FOR "+sql, params...); err != nil {
+		module.failed(err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %w", fn.Name(), ctxErr)
+		}
+		if module.autosavepoints {
+			if _, err := module.tx.Exec(module.ctx, "ROLLBACK TO SAVEPOINT "+module.savepointname); err != nil {
+				// this is an unrecoverable system error
+				return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+			}
+			module.txstate = txActive
+		}
+
+		return starlark.Tuple{starlark.None, newError(err)}, nil
+	}
+
+	// the cursor outlives the savepoint, so a cursor that is never iterated
+	// does not leave it open
+	if module.autosavepoints {
+		if _, err := module.tx.Exec(module.ctx, "RELEASE SAVEPOINT "+module.savepointname); err != nil {
+			// this is an unrecoverable system error
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+	}
+
+	return starlark.Tuple{&Cursor{
+		module:    module,
+		name:      name,
+		fetchSize: fetchSize,
+		thread:    thread,
+	}, starlark.None}, nil
+}
@@ -2,43 +2,150 @@ package modpostgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/protosam/pgstar/executor/modules"
+	"github.com/protosam/pgstar/executor/modules/modhttp"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
 
 const (
-	ModuleName      = "db"
-	StateNameDBPool = "postgres/dbpool"
+	ModuleName         = "db"
+	StateNameDBPool    = "postgres/dbpool"
+	StateNameTxOptions = "postgres/txoptions"
+
+	StateNameQueryTimeout = "postgres/querytimeout"
+	StateNameReplicaPool  = "postgres/replicapool"
+
+	StateNameLocalSettings  = "postgres/localsettings"
+	StateNameAcquireTimeout = "postgres/acquiretimeout"
 )
 
 type Module struct {
-	tx             pgx.Tx
-	ctx            context.Context
-	autosavepoints bool
-	savepointname  string
+	loader              modules.ModuleLoader
+	dbpool              *pgxpool.Pool
+	primarypool         *pgxpool.Pool
+	threadName          string
+	tx                  pgx.Tx
+	txstate             txState
+	txOptions           pgx.TxOptions
+	localSettings       *LocalSettings
+	queries             Queries
+	ctx                 context.Context
+	queryTimeout        time.Duration
+	acquireTimeout      time.Duration
+	autosavepoints      bool
+	savepointname       string
+	commitOnErrorStatus bool
+	atomicDepth         int
+	retryErr            error
+}
+
+// StateNameForDatabase returns the state name holding the pool of a named database
+func StateNameForDatabase(name string) string {
+	return StateNameDBPool + "/" + name
 }
 
 func Constructor(loader modules.ModuleLoader) (modules.LocalizedModule, error) {
 	var dbpool *pgxpool.Pool
-	err := loader.GetState(StateNameDBPool, &dbpool)
+	if err := loader.GetState(StateNameDBPool, &dbpool); err != nil {
+		return nil, err
+	}
+	module, err := newModule(loader, dbpool)
 	if err != nil {
 		return nil, err
 	}
 
-	module := &Module{}
+	// role and settings of the route only apply to its default database,
+	// databases added with addDatabase() are left as they are
+	var localSettings *LocalSettings
+	if err := loader.GetState(StateNameLocalSettings, &localSettings); err == nil {
+		module.localSettings = localSettings
+	} else if !errors.Is(err, modules.ErrStateNotFound) {
+		return nil, err
+	}
+
+	// read-only routes run on the replica unless db.primary() is called
+	var replicapool *pgxpool.Pool
+	if err := loader.GetState(StateNameReplicaPool, &replicapool); err == nil {
+		module.primarypool = dbpool
+		module.dbpool = replicapool
+	} else if !errors.Is(err, modules.ErrStateNotFound) {
+		return nil, err
+	}
+
+	return module, nil
+}
+
+// NamedConstructor returns a constructor for a database configured with addDatabase()
+func NamedConstructor(name string) modules.ModuleExporterFn {
+	return func(loader modules.ModuleLoader) (modules.LocalizedModule, error) {
+		var dbpool *pgxpool.Pool
+		if err := loader.GetState(StateNameForDatabase(name), &dbpool); err != nil {
+			if errors.Is(err, modules.ErrStateNotFound) {
+				return nil, fmt.Errorf("database %q is not configured", name)
+			}
+			return nil, err
+		}
+		return newModule(loader, dbpool)
+	}
+}
+
+func newModule(loader modules.ModuleLoader, dbpool *pgxpool.Pool) (*Module, error) {
+	module := &Module{
+		loader:     loader,
+		dbpool:     dbpool,
+		threadName: loader.GetThreadName(),
+	}
+
+	// transaction options are optional and configured per route
+	var txOptions *pgx.TxOptions
+	if err := loader.GetState(StateNameTxOptions, &txOptions); err == nil {
+		module.txOptions = *txOptions
+	} else if !errors.Is(err, modules.ErrStateNotFound) {
+		return nil, err
+	}
+
+	// the query catalog is built by addQuery() and addQueriesFromDir()
+	var queries *Queries
+	if err := loader.GetState(StateNameQueries, &queries); err == nil {
+		module.queries = *queries
+	} else if !errors.Is(err, modules.ErrStateNotFound) {
+		return nil, err
+	}
+
+	// query timeouts are optional and configured globally or per route
+	var queryTimeout *time.Duration
+	if err := loader.GetState(StateNameQueryTimeout, &queryTimeout); err == nil {
+		module.queryTimeout = *queryTimeout
+	} else if !errors.Is(err, modules.ErrStateNotFound) {
+		return nil, err
+	}
+
+	// waiting for a pool connection is optionally bounded
+	var acquireTimeout *time.Duration
+	if err := loader.GetState(StateNameAcquireTimeout, &acquireTimeout); err == nil {
+		module.acquireTimeout = *acquireTimeout
+	} else if !errors.Is(err, modules.ErrStateNotFound) {
+		return nil, err
+	}
+
+	// database calls are cancelled when the client goes away
 	module.ctx = context.Background()
-	module.tx, err = dbpool.Begin(module.ctx)
-	if err != nil {
-		// w.WriteHeader(http.StatusInternalServerError)
-		return nil, fmt.Errorf("%s: failed to start transaction: %w", loader.GetThreadName(), err)
+	var r *http.Request
+	if err := loader.GetState(modhttp.StateNameReader, &r); err == nil {
+		module.ctx = r.Context()
 	}
+
+	// the transaction is started by the first statement, see module.begin()
 	module.savepointname = strings.Join([]string{"pgstar", strings.ReplaceAll(uuid.Must(uuid.NewRandom()).String(), "-", "")}, "_")
 
 	return module, nil
@@ -49,10 +156,29 @@ func (module *Module) Exports() starlark.StringDict {
 		"exports": starlarkstruct.FromStringDict(
 			starlark.String(ModuleName),
 			starlark.StringDict{
-				"savepoints": starlark.NewBuiltin("db.savepoints", module.savepoints),
-				"query":      starlark.NewBuiltin("db.query", module.query),
-				"first":      starlark.NewBuiltin("db.first", module.first),
-				"exec":       starlark.NewBuiltin("db.exec", module.exec),
+				"savepoints":          starlark.NewBuiltin("db.savepoints", module.savepoints),
+				"commitOnErrorStatus": starlark.NewBuiltin("db.commitOnErrorStatus", module.commitOnErrorStatusFn),
+				"query":               starlark.NewBuiltin("db.query", module.query),
+				"cursor":              starlark.NewBuiltin("db.cursor", module.cursor),
+				"named":               starlark.NewBuiltin("db.named", module.named),
+				"first":               starlark.NewBuiltin("db.first", module.first),
+				"exec":                starlark.NewBuiltin("db.exec", module.exec),
+				"batch":               starlark.NewBuiltin("db.batch", module.batch),
+				"copyFrom":            starlark.NewBuiltin("db.copyFrom", module.copyFrom),
+				"notify":              starlark.NewBuiltin("db.notify", module.notify),
+				"use":                 starlark.NewBuiltin("db.use", module.use),
+				"primary":             starlark.NewBuiltin("db.primary", module.primary),
+				"asRole":              starlark.NewBuiltin("db.asRole", module.asRole),
+				"setLocal":            starlark.NewBuiltin("db.setLocal", module.setLocal),
+				"commit":              starlark.NewBuiltin("db.commit", module.commit),
+				"rollback":            starlark.NewBuiltin("db.rollback", module.rollback),
+				"savepoint":           starlark.NewBuiltin("db.savepoint", module.savepoint),
+				"rollbackTo":          starlark.NewBuiltin("db.rollbackTo", module.rollbackTo),
+				"release":             starlark.NewBuiltin("db.release", module.release),
+				"atomic":              starlark.NewBuiltin("db.atomic", module.atomic),
+				"txOptions":           starlark.NewBuiltin("db.txOptions", module.txOptionsFn),
+				"poolStats":           starlark.NewBuiltin("db.poolStats", module.poolStats),
+				"timeout":             starlark.NewBuiltin("db.timeout", module.timeout),
 			},
 		),
 	}
@@ -62,11 +188,39 @@ func (module *Module) Name() string {
 	return ModuleName
 }
 
-func (module *Module) Destroy(loader modules.ModuleLoader) error {
-	defer module.tx.Rollback(module.ctx)
+func (module *Module) Destroy(loader modules.ModuleLoader, outcome modules.Outcome) error {
+	// no statements were run, so there is nothing to commit
+	if module.tx == nil {
+		return nil
+	}
+
+	// the script already ended the transaction with db.commit() or db.rollback()
+	if module.txstate == txCommitted || module.txstate == txRolledBack {
+		if module.retryErr != nil {
+			return fmt.Errorf("%s: transaction was not committed: %w: %w", loader.GetThreadName(), modules.ErrRetryable, module.retryErr)
+		}
+		return nil
+	}
+
+	// rolling back must still happen when the request context is cancelled
+	rollbackCtx := context.WithoutCancel(module.ctx)
+	defer module.tx.Rollback(rollbackCtx)
+
+	// a serialization failure or deadlock leaves nothing worth committing
+	if !outcome.Succeeded(module.commitOnErrorStatus) || (module.txstate == txAborted && module.retryErr != nil) {
+		if err := module.tx.Rollback(rollbackCtx); err != nil {
+			return fmt.Errorf("%s: unable to rollback transaction after %s: %w", loader.GetThreadName(), outcome.State, err)
+		}
+		if module.retryErr != nil {
+			return fmt.Errorf("%s: transaction was rolled back: %w: %w", loader.GetThreadName(), modules.ErrRetryable, module.retryErr)
+		}
+		return nil
+	}
 
 	if err := module.tx.Commit(module.ctx); err != nil {
-		// w.WriteHeader(http.StatusInternalServerError)
+		if isRetryable(err) {
+			return fmt.Errorf("%s: unable to commit transaction: %w: %w", loader.GetThreadName(), modules.ErrRetryable, err)
+		}
 		return fmt.Errorf("%s: unable to commit transaction: %w", loader.GetThreadName(), err)
 	}
 
@@ -80,15 +234,35 @@ func (module *Module) savepoints(thread *starlark.Thread, fn *starlark.Builtin,
 	return starlark.None, nil
 }
 
+func (module *Module) commitOnErrorStatusFn(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "enable", &module.commitOnErrorStatus); err != nil {
+		return starlark.None, err
+	}
+	return starlark.None, nil
+}
+
 func (module *Module) query(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var sql string
-	pgargs := starlark.NewList(nil)
+	var pgargs starlark.Value = starlark.NewList(nil)
 	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "sql", &sql, "args", &pgargs); err != nil {
 		return starlark.None, err
 	}
 
-	params := pgargsToStarlarkValue(thread, fn, pgargs)
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	sql, params, err := bindArgs(thread, fn, sql, pgargs)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	return module.runQuery(thread, fn, "", sql, params)
+}
 
+// runQuery runs sql and returns its rows, when statement is set sql is
+// prepared under that name and executed by it
+func (module *Module) runQuery(thread *starlark.Thread, fn *starlark.Builtin, statement, sql string, params []interface{}) (starlark.Value, error) {
 	if module.autosavepoints {
 		if _, err := module.tx.Exec(module.ctx, "SAVEPOINT "+module.savepointname); err != nil {
 			// this is an unrecoverable system error
@@ -99,8 +273,16 @@ func (module *Module) query(thread *starlark.Thread, fn *starlark.Builtin, args
 		}
 	}
 
-	rows, err := module.tx.Query(module.ctx, sql, params...)
+	ctx, cancel := module.statementContext()
+	rows, err := module.queryStatement(ctx, statement, sql, params)
 	if err != nil {
+		// the context has to be checked before cancel() sets its error
+		ctxErr := ctx.Err()
+		cancel()
+		module.failed(err)
+		if ctxErr != nil {
+			return &starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %w", fn.Name(), ctxErr)
+		}
 		if module.autosavepoints {
 			if _, err := module.tx.Exec(module.ctx, "ROLLBACK TO SAVEPOINT "+module.savepointname); err != nil {
 				// this is an unrecoverable system error
@@ -109,11 +291,12 @@ func (module *Module) query(thread *starlark.Thread, fn *starlark.Builtin, args
 					starlark.None,
 				}, fmt.Errorf("%s(): %s", fn.Name(), err)
 			}
+			module.txstate = txActive
 		}
 
 		return &starlark.Tuple{
 			starlark.None,
-			starlark.String(fmt.Sprintf("%s", err)),
+			newError(err),
 		}, nil
 	}
 
@@ -135,11 +318,24 @@ func (module *Module) query(thread *starlark.Thread, fn *starlark.Builtin, args
 		rows:   rows,
 		module: module,
 		thread: thread,
+		cancel: cancel,
 	}
 
 	return &starlark.Tuple{slrows, starlark.None}, nil
 }
 
+// queryStatement runs sql, or the prepared statement when statement is set
+func (module *Module) queryStatement(ctx context.Context, statement, sql string, params []interface{}) (pgx.Rows, error) {
+	if statement != "" {
+		// preparing is a no-op once the connection has prepared the statement
+		if _, err := module.tx.Prepare(ctx, statement, sql); err != nil {
+			return nil, err
+		}
+		sql = statement
+	}
+	return module.tx.Query(ctx, sql, params...)
+}
+
 func (module *Module) first(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var rows *Rows
 	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "rows", &rows); err != nil {
@@ -149,8 +345,11 @@ func (module *Module) first(thread *starlark.Thread, fn *starlark.Builtin, args
 	defer rows.Iterate().Done()
 
 	if rows.rows.Next() {
-		values, _ := rows.rows.Values()
-		return parseRow(values, rows.fields), nil
+		row, err := parseRow(rows.rows)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+		return row, nil
 	}
 
 	return starlark.None, nil
@@ -158,12 +357,19 @@ func (module *Module) first(thread *starlark.Thread, fn *starlark.Builtin, args
 
 func (module *Module) exec(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var sql string
-	pgargs := starlark.NewList(nil)
+	var pgargs starlark.Value = starlark.NewList(nil)
 	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "sql", &sql, "args", &pgargs); err != nil {
 		return starlark.None, err
 	}
 
-	params := pgargsToStarlarkValue(thread, fn, pgargs)
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	sql, params, err := bindArgs(thread, fn, sql, pgargs)
+	if err != nil {
+		return starlark.None, err
+	}
 
 	if module.autosavepoints {
 		if _, err := module.tx.Exec(module.ctx, "SAVEPOINT "+module.savepointname); err != nil {
@@ -175,20 +381,27 @@ func (module *Module) exec(thread *starlark.Thread, fn *starlark.Builtin, args s
 		}
 	}
 
-	cmdTag, err := module.tx.Exec(module.ctx, sql, params...)
+	ctx, cancel := module.statementContext()
+	defer cancel()
+	cmdTag, err := module.tx.Exec(ctx, sql, params...)
 	if err != nil {
+		module.failed(err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return &starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %w", fn.Name(), ctxErr)
+		}
 		if module.autosavepoints {
 			if _, err := module.tx.Exec(module.ctx, "ROLLBACK TO SAVEPOINT "+module.savepointname); err != nil {
 				return &starlark.Tuple{
 					starlark.None,
-					starlark.String(fmt.Sprintf("%s", err)),
+					newError(err),
 				}, fmt.Errorf("%s(): %s", fn.Name(), err)
 			}
+			module.txstate = txActive
 		}
 
 		return &starlark.Tuple{
 			starlark.MakeInt64(cmdTag.RowsAffected()),
-			starlark.String(fmt.Sprintf("%s", err)),
+			newError(err),
 		}, nil
 	}
 
@@ -196,7 +409,7 @@ func (module *Module) exec(thread *starlark.Thread, fn *starlark.Builtin, args s
 		if _, err := module.tx.Exec(module.ctx, "RELEASE SAVEPOINT "+module.savepointname); err != nil {
 			return &starlark.Tuple{
 				starlark.None,
-				starlark.String(fmt.Sprintf("%s", err)),
+				newError(err),
 			}, fmt.Errorf("%s(): %s", fn.Name(), err)
 		}
 	}
@@ -206,3 +419,105 @@ func (module *Module) exec(thread *starlark.Thread, fn *starlark.Builtin, args s
 		starlark.None,
 	}, nil
 }
+
+// notify sends a notification that is delivered when the transaction commits
+func (module *Module) notify(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var channel, payload string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "channel", &channel, "payload?", &payload); err != nil {
+		return starlark.None, err
+	}
+
+	result, err := module.exec(thread, fn, starlark.Tuple{
+		starlark.String("SELECT pg_notify($1, $2)"),
+		starlark.NewList([]starlark.Value{starlark.String(channel), starlark.String(payload)}),
+	}, nil)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	return result.(*starlark.Tuple).Index(1), nil
+}
+
+// use returns the module of a database configured with addDatabase(), it has
+// its own transaction that is committed or rolled back when the script ends
+func (module *Module) use(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return starlark.None, err
+	}
+
+	exports, err := module.loader.LoadModule("pgstar/postgres/" + name)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s(): %w", fn.Name(), err)
+	}
+
+	return exports["exports"], nil
+}
+
+// primary moves a read-only route from the replica back to the primary so
+// reads can see recent writes, it must be called before the first statement
+func (module *Module) primary(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+
+	if module.primarypool == nil {
+		return starlark.None, nil
+	}
+
+	if module.tx != nil {
+		return starlark.None, fmt.Errorf("%s(): must be called before the first statement", fn.Name())
+	}
+
+	module.dbpool = module.primarypool
+	module.primarypool = nil
+	return starlark.None, nil
+}
+
+// poolStats reports the state of the connection pool used by the module
+func (module *Module) poolStats(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+
+	stat := module.dbpool.Stat()
+	return starlarkstruct.FromStringDict(
+		starlark.String("db.poolStats"),
+		starlark.StringDict{
+			"acquireCount":            starlark.MakeInt64(stat.AcquireCount()),
+			"acquireDuration":         starlark.Float(stat.AcquireDuration().Seconds()),
+			"acquiredConns":           starlark.MakeInt(int(stat.AcquiredConns())),
+			"canceledAcquireCount":    starlark.MakeInt64(stat.CanceledAcquireCount()),
+			"constructingConns":       starlark.MakeInt(int(stat.ConstructingConns())),
+			"emptyAcquireCount":       starlark.MakeInt64(stat.EmptyAcquireCount()),
+			"idleConns":               starlark.MakeInt(int(stat.IdleConns())),
+			"maxConns":                starlark.MakeInt(int(stat.MaxConns())),
+			"totalConns":              starlark.MakeInt(int(stat.TotalConns())),
+			"newConnsCount":           starlark.MakeInt64(stat.NewConnsCount()),
+			"maxLifetimeDestroyCount": starlark.MakeInt64(stat.MaxLifetimeDestroyCount()),
+			"maxIdleDestroyCount":     starlark.MakeInt64(stat.MaxIdleDestroyCount()),
+		},
+	), nil
+}
+
+// statementContext returns the context for a single statement, bounded by the query timeout
+func (module *Module) statementContext() (context.Context, context.CancelFunc) {
+	if module.queryTimeout > 0 {
+		return context.WithTimeout(module.ctx, module.queryTimeout)
+	}
+	return context.WithCancel(module.ctx)
+}
+
+func (module *Module) timeout(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seconds float64
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "seconds", &seconds); err != nil {
+		return starlark.None, err
+	}
+	if seconds < 0 {
+		return starlark.None, fmt.Errorf("%s(): seconds must not be negative", fn.Name())
+	}
+
+	// zero disables the timeout
+	module.queryTimeout = time.Duration(seconds * float64(time.Second))
+	return starlark.None, nil
+}
@@ -0,0 +1,405 @@
+package modpostgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/protosam/pgstar/executor/modules"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// testLoader is a module loader holding the states set by a test
+type testLoader struct {
+	state map[string]any
+}
+
+func (loader *testLoader) SetState(name string, ref any) error {
+	loader.state[name] = ref
+	return nil
+}
+
+func (loader *testLoader) GetState(name string, dest any) error {
+	source, ok := loader.state[name]
+	if !ok {
+		return modules.ErrStateNotFound
+	}
+	reflect.ValueOf(dest).Elem().Set(reflect.ValueOf(source))
+	return nil
+}
+
+func (loader *testLoader) GetThreadName() string {
+	return "test"
+}
+
+func (loader *testLoader) LoadModule(string) (starlark.StringDict, error) {
+	return nil, nil
+}
+
+// testPool connects to $PGSTAR_TEST_POSTGRES_CONFIG, tests are skipped without it
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	config := os.Getenv("PGSTAR_TEST_POSTGRES_CONFIG")
+	if config == "" {
+		t.Skip("PGSTAR_TEST_POSTGRES_CONFIG is not set")
+	}
+	dbpool, err := pgxpool.New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dbpool.Close)
+	return dbpool
+}
+
+// runScript runs src with the db module of a new transaction
+func runScript(t *testing.T, dbpool *pgxpool.Pool, src string) error {
+	t.Helper()
+	loader := &testLoader{state: map[string]any{StateNameDBPool: dbpool}}
+	module, err := Constructor(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = execScript(module.(*Module), src)
+	module.Destroy(loader, modules.NewOutcome(err))
+	return err
+}
+
+// execScript runs src with the exports of module
+func execScript(module *Module, src string) error {
+	predeclared := starlark.StringDict{"db": module.Exports()["exports"]}
+	fileOptions := &syntax.FileOptions{GlobalReassign: true, TopLevelControl: true}
+	_, err := starlark.ExecFileOptions(fileOptions, &starlark.Thread{Name: "test"}, "test.star", src, predeclared)
+	return err
+}
+
+func TestQueryFailureReturnsError(t *testing.T) {
+	dbpool := testPool(t)
+
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"failing statement", `
+rows, err = db.query("SELECT 1/0")
+if rows != None or err == None:
+    fail("expected (None, err), got", rows, err)
+`},
+		{"failing named statement", `
+rows, err = db.query("SELECT 1/:zero", {"zero": 0})
+if rows != None or err == None:
+    fail("expected (None, err), got", rows, err)
+`},
+		{"autosavepoint recovers", `
+db.savepoints(True)
+rows, err = db.query("SELECT 1/0")
+if rows != None or err == None:
+    fail("expected (None, err), got", rows, err)
+rows, err = db.query("SELECT 1 AS one")
+if err != None:
+    fail("transaction was not recovered:", err)
+for row in rows:
+    if row["one"] != 1:
+        fail("unexpected row", row)
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runScript(t, dbpool, tt.src); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLocalSettingsOnlyApplyToDefaultDatabase(t *testing.T) {
+	var dbpool *pgxpool.Pool
+	loader := &testLoader{state: map[string]any{
+		StateNameDBPool:               dbpool,
+		StateNameForDatabase("other"): dbpool,
+		StateNameLocalSettings:        &LocalSettings{Role: "authenticated"},
+	}}
+
+	module, err := Constructor(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module.(*Module).localSettings == nil {
+		t.Error("default database does not apply the local settings")
+	}
+
+	named, err := NamedConstructor("other")(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if named.(*Module).localSettings != nil {
+		t.Error("named database applies the local settings of the route")
+	}
+}
+
+func TestCursorReleasesAutosavepoint(t *testing.T) {
+	dbpool := testPool(t)
+	loader := &testLoader{state: map[string]any{StateNameDBPool: dbpool}}
+	module, err := newModule(loader, dbpool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer module.Destroy(loader, modules.NewOutcome(errors.New("rollback")))
+
+	err = execScript(module, `
+db.savepoints(True)
+cursor, err = db.cursor("SELECT generate_series(1, 10)", fetchSize=3)
+if err != None:
+    fail(err)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cursor is never iterated, its savepoint must not be left open
+	if _, err := module.tx.Exec(context.Background(), "ROLLBACK TO SAVEPOINT "+module.savepointname); err == nil {
+		t.Error("savepoint of the cursor is still open")
+	}
+}
+
+func TestNamedQuery(t *testing.T) {
+	dbpool := testPool(t)
+	query, err := PrepareQuery(context.Background(), dbpool, "add", "SELECT :a::int + :b::int AS sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(query.Params) != 2 || query.Params[0].Name != "a" || query.Params[1].Name != "b" {
+		t.Fatalf("params = %+v", query.Params)
+	}
+	if len(query.Fields) != 1 || query.Fields[0].Name != "sum" {
+		t.Fatalf("fields = %+v", query.Fields)
+	}
+
+	loader := &testLoader{state: map[string]any{
+		StateNameDBPool:  dbpool,
+		StateNameQueries: &Queries{"add": query},
+	}}
+	module, err := Constructor(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer module.Destroy(loader, modules.NewOutcome(nil))
+
+	err = execScript(module.(*Module), `
+for args in [{"a": 1, "b": 2}, [1, 2]]:
+    rows, err = db.named("add", args)
+    if err != None:
+        fail(err)
+    row = db.first(rows)
+    if row["sum"] != 3:
+        fail("unexpected row", row)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := execScript(module.(*Module), `db.named("add", [1])`); err == nil {
+		t.Error("expected an error for a missing argument")
+	}
+}
+
+// testTable creates a table for a test and drops it when the test ends
+func testTable(t *testing.T, dbpool *pgxpool.Pool) string {
+	t.Helper()
+	table := "pgstar_test_" + strings.ReplaceAll(uuid.Must(uuid.NewRandom()).String(), "-", "")
+	if _, err := dbpool.Exec(context.Background(), "CREATE TABLE "+table+" (id int PRIMARY KEY, name text NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dbpool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table)
+	})
+	return table
+}
+
+// tableIDs returns the committed ids of a test table in order
+func tableIDs(t *testing.T, dbpool *pgxpool.Pool, table string) []int32 {
+	t.Helper()
+	rows, err := dbpool.Query(context.Background(), "SELECT id FROM "+table+" ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestTransactionControl(t *testing.T) {
+	dbpool := testPool(t)
+
+	tests := []struct {
+		name  string
+		src   string
+		fails bool
+		ids   []int32
+	}{
+		{"commit", `
+_, err = db.exec("INSERT INTO TABLE (id, name) VALUES (1, 'a')", [])
+if err != None:
+    fail(err)
+err = db.commit()
+if err != None:
+    fail(err)
+# the transaction is over, so this fails the script
+db.exec("INSERT INTO TABLE (id, name) VALUES (2, 'b')", [])
+`, true, []int32{1}},
+		{"rollback", `
+_, err = db.exec("INSERT INTO TABLE (id, name) VALUES (1, 'a')", [])
+if err != None:
+    fail(err)
+db.rollback()
+`, false, nil},
+		{"commit without statements", `
+err = db.commit()
+if err != None:
+    fail(err)
+`, false, nil},
+		{"nested atomic rolls back the inner callable", `
+def inner():
+    db.exec("INSERT INTO TABLE (id, name) VALUES (2, 'inner')", [])
+    fail("inner failed")
+
+def outer():
+    db.exec("INSERT INTO TABLE (id, name) VALUES (1, 'outer')", [])
+    result, err = db.atomic(inner)
+    if result != None or err == None:
+        fail("inner error was not returned")
+    return "done"
+
+result, err = db.atomic(outer)
+if err != None or result != "done":
+    fail("unexpected result", result, err)
+`, false, []int32{1}},
+		{"atomic recovers an aborted transaction", `
+db.exec("INSERT INTO TABLE (id, name) VALUES (1, 'a')", [])
+
+def duplicate():
+    _, err = db.exec("INSERT INTO TABLE (id, name) VALUES (1, 'b')", [])
+    return err
+
+result, err = db.atomic(duplicate)
+if err == None:
+    fail("aborted transaction was not reported")
+_, err = db.exec("INSERT INTO TABLE (id, name) VALUES (2, 'c')", [])
+if err != None:
+    fail(err)
+`, false, []int32{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := testTable(t, dbpool)
+			err := runScript(t, dbpool, strings.ReplaceAll(tt.src, "TABLE", table))
+			if (err != nil) != tt.fails {
+				t.Fatalf("script error = %v, want failure %v", err, tt.fails)
+			}
+			if ids := tableIDs(t, dbpool, table); !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dbpool := testPool(t)
+
+	tests := []struct {
+		name string
+		src  string
+		ids  []int32
+	}{
+		{"autosavepoints recover from a failing statement", `
+db.savepoints(True)
+results = db.batch([
+    "INSERT INTO TABLE (id, name) VALUES (1, 'a')",
+    ("INSERT INTO TABLE (id, name) VALUES ($1, $2)", [1, "duplicate"]),
+    ("INSERT INTO TABLE (id, name) VALUES (:id, :name)", {"id": 2, "name": "b"}),
+    "SELECT count(*) AS total FROM TABLE",
+])
+errors = [err != None for _, err in results]
+if errors != [False, True, False, False]:
+    fail("unexpected errors", results)
+if results[1][1].code != "23505":
+    fail("unexpected error", results[1][1])
+if results[2][0].rowsAffected != 1 or results[3][0].rows[0]["total"] != 2:
+    fail("unexpected results", results)
+`, []int32{1, 2}},
+		{"without savepoints the rest is not executed", `
+results = db.batch([
+    "INSERT INTO TABLE (id, name) VALUES (1, 'a')",
+    "INSERT INTO TABLE (id, name) VALUES (1, 'duplicate')",
+    "INSERT INTO TABLE (id, name) VALUES (2, 'b')",
+])
+errors = [err != None for _, err in results]
+if errors != [False, True, True]:
+    fail("unexpected errors", results)
+`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := testTable(t, dbpool)
+			if err := runScript(t, dbpool, strings.ReplaceAll(tt.src, "TABLE", table)); err != nil {
+				t.Fatal(err)
+			}
+			if ids := tableIDs(t, dbpool, table); !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	dbpool := testPool(t)
+
+	tests := []struct {
+		name  string
+		src   string
+		fails bool
+		ids   []int32
+	}{
+		{"copies every row", `
+copied, err = db.copyFrom("TABLE", ["id", "name"], [(1, "a"), [2, "b"], (3, "c")])
+if err != None or copied != 3:
+    fail("unexpected result", copied, err)
+`, false, []int32{1, 2, 3}},
+		{"unknown column is an error", `
+db.savepoints(True)
+copied, err = db.copyFrom("TABLE", ["id", "missing"], [(1, "a")])
+if copied != None or err == None or err.code != "42703":
+    fail("unexpected result", copied, err)
+_, err = db.query("INSERT INTO TABLE (id, name) VALUES (2, 'b')")
+if err != None:
+    fail(err)
+`, false, []int32{2}},
+		{"row of the wrong length fails the script", `
+db.copyFrom("TABLE", ["id", "name"], [(1, "a"), (2,)])
+`, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := testTable(t, dbpool)
+			err := runScript(t, dbpool, strings.ReplaceAll(tt.src, "TABLE", table))
+			if (err != nil) != tt.fails {
+				t.Fatalf("script error = %v, fails = %v", err, tt.fails)
+			}
+			if ids := tableIDs(t, dbpool, table); !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package modpostgres
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Error exposes database errors to scripts with the fields reported by postgres
+type Error struct {
+	starlarkstruct.Struct
+	err error
+}
+
+func newError(err error) *Error {
+	fields := starlark.StringDict{
+		"code":       starlark.String(""),
+		"message":    starlark.String(err.Error()),
+		"detail":     starlark.String(""),
+		"hint":       starlark.String(""),
+		"constraint": starlark.String(""),
+		"table":      starlark.String(""),
+		"column":     starlark.String(""),
+		"severity":   starlark.String(""),
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		fields["code"] = starlark.String(pgErr.Code)
+		fields["message"] = starlark.String(pgErr.Message)
+		fields["detail"] = starlark.String(pgErr.Detail)
+		fields["hint"] = starlark.String(pgErr.Hint)
+		fields["constraint"] = starlark.String(pgErr.ConstraintName)
+		fields["table"] = starlark.String(pgErr.TableName)
+		fields["column"] = starlark.String(pgErr.ColumnName)
+		fields["severity"] = starlark.String(pgErr.Severity)
+	}
+
+	return &Error{
+		Struct: *starlarkstruct.FromStringDict(starlark.String("db.error"), fields),
+		err:    err,
+	}
+}
+
+// String keeps the previous string form of errors for printing
+func (e *Error) String() string {
+	return e.err.Error()
+}
+
+func (e *Error) Type() string {
+	return "db.error"
+}
+
+func (e *Error) Truth() starlark.Bool {
+	return true
+}
@@ -0,0 +1,206 @@
+package modpostgres
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.starlark.net/starlark"
+)
+
+// bindArgs converts starlark arguments into pgx parameters, a dict of named
+// arguments rewrites :name and @name placeholders into positional parameters
+func bindArgs(thread *starlark.Thread, fn *starlark.Builtin, sql string, pgargs starlark.Value) (string, []interface{}, error) {
+	switch args := pgargs.(type) {
+	case *starlark.List:
+		params, err := pgargsToStarlarkValue(thread, fn, args)
+		return sql, params, err
+	case starlark.Tuple:
+		params, err := pgargsToStarlarkValue(thread, fn, starlark.NewList(args))
+		return sql, params, err
+	case *starlark.Dict:
+		return bindNamedArgs(fn, sql, args)
+	}
+
+	return sql, nil, fmt.Errorf("%s(): args must be a list, tuple or dict, got %s", fn.Name(), pgargs.Type())
+}
+
+func bindNamedArgs(fn *starlark.Builtin, sql string, args *starlark.Dict) (string, []interface{}, error) {
+	named := map[string]starlark.Value{}
+	for _, item := range args.Items() {
+		name, ok := starlark.AsString(item[0])
+		if !ok {
+			return sql, nil, fmt.Errorf("%s(): named argument keys must be strings, got %s", fn.Name(), item[0].Type())
+		}
+		named[name] = item[1]
+	}
+
+	rewritten, names, err := rewriteNamedPlaceholders(sql, func(name string, position int) error {
+		if _, ok := named[name]; !ok {
+			return fmt.Errorf("%s(): named parameter %q at position %d is missing from args", fn.Name(), name, position)
+		}
+		return nil
+	})
+	if err != nil {
+		return sql, nil, err
+	}
+
+	used := map[string]bool{}
+	for _, name := range names {
+		used[name] = true
+	}
+	var unknown []string
+	for name := range named {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return sql, nil, fmt.Errorf("%s(): args %s are not used by any placeholder in the sql", fn.Name(), strings.Join(unknown, ", "))
+	}
+
+	params := []interface{}{}
+	for i, name := range names {
+		param, err := starlarkToGoValue(named[name])
+		if err != nil {
+			return sql, nil, fmt.Errorf("%s(): argument %q ($%d): %w", fn.Name(), name, i+1, err)
+		}
+		params = append(params, param)
+	}
+
+	return rewritten, params, nil
+}
+
+// rewriteNamedPlaceholders replaces :name and @name with $n, skipping string
+// literals, quoted identifiers, comments and :: casts. Each distinct name is
+// bound once and positions passed to check are 1-based like postgres reports.
+func rewriteNamedPlaceholders(sql string, check func(name string, position int) error) (string, []string, error) {
+	var out strings.Builder
+	var names []string
+	indexes := map[string]int{}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			// E'...' strings allow backslash escapes, the E must not end an identifier
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && !followsIdent(sql[:i-1])
+			end := skipQuoted(sql, i, c, escapes)
+			out.WriteString(sql[i:end])
+			i = end
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			out.WriteString(sql[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql)
+			} else {
+				end = i + 2 + end + 2
+			}
+			out.WriteString(sql[i:end])
+			i = end
+		case c == '$' && dollarQuoteTag(sql[i:]) != "":
+			tag := dollarQuoteTag(sql[i:])
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				end = len(sql)
+			} else {
+				end = i + len(tag) + end + len(tag)
+			}
+			out.WriteString(sql[i:end])
+			i = end
+		case c == ':' && strings.HasPrefix(sql[i:], "::"):
+			out.WriteString("::")
+			i += 2
+		case (c == ':' || c == '@') && i+1 < len(sql) && isIdentStart(sql[i+1:]) && !followsPlaceholderBoundary(sql[:i]):
+			end := i + 1
+			for end < len(sql) {
+				r, size := utf8.DecodeRuneInString(sql[end:])
+				if !isIdentRune(r) {
+					break
+				}
+				end += size
+			}
+			name := sql[i+1 : end]
+			if err := check(name, utf8.RuneCountInString(sql[:i])+1); err != nil {
+				return sql, nil, err
+			}
+			if _, ok := indexes[name]; !ok {
+				names = append(names, name)
+				indexes[name] = len(names)
+			}
+			out.WriteString("$" + strconv.Itoa(indexes[name]))
+			i = end
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+
+	return out.String(), names, nil
+}
+
+// skipQuoted returns the index after a quoted string, doubled quotes are escapes
+func skipQuoted(sql string, start int, quote byte, escapes bool) int {
+	for i := start + 1; i < len(sql); i++ {
+		if escapes && sql[i] == '\\' {
+			i++
+			continue
+		}
+		if sql[i] == quote {
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarQuoteTag returns the opening tag of a dollar quoted string such as $$ or $body$
+func dollarQuoteTag(sql string) string {
+	for i := 1; i < len(sql); i++ {
+		if sql[i] == '$' {
+			return sql[:i+1]
+		}
+		r, _ := utf8.DecodeRuneInString(sql[i:])
+		if !isIdentRune(r) || (i == 1 && unicode.IsDigit(r)) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// followsIdent reports if sql ends with an identifier or number character
+func followsIdent(sql string) bool {
+	r, size := utf8.DecodeLastRuneInString(sql)
+	return size > 0 && isIdentRune(r)
+}
+
+// followsPlaceholderBoundary reports if a : or @ at the end of sql can not
+// start a placeholder, e.g. the bounds of array slices such as arr[1:n]
+func followsPlaceholderBoundary(sql string) bool {
+	if strings.HasSuffix(sql, "[") || strings.HasSuffix(sql, ":") {
+		return true
+	}
+	return followsIdent(sql)
+}
+
+func isIdentStart(sql string) bool {
+	r, _ := utf8.DecodeRuneInString(sql)
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
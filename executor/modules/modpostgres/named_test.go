@@ -0,0 +1,69 @@
+package modpostgres
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteNamedPlaceholders(t *testing.T) {
+	tests := []struct {
+		name  string
+		sql   string
+		want  string
+		names []string
+	}{
+		{"colon", "SELECT * FROM users WHERE id = :id", "SELECT * FROM users WHERE id = $1", []string{"id"}},
+		{"at", "SELECT @a, @b", "SELECT $1, $2", []string{"a", "b"}},
+		{"repeated name", "SELECT :a, :b, :a", "SELECT $1, $2, $1", []string{"a", "b"}},
+		{"no whitespace", "WHERE a=:a AND (b=:b)", "WHERE a=$1 AND (b=$2)", []string{"a", "b"}},
+		{"unicode name", "SELECT :naïve", "SELECT $1", []string{"naïve"}},
+		{"cast", "SELECT :v::int, now()::date", "SELECT $1::int, now()::date", []string{"v"}},
+		{"cast of placeholder", "SELECT CAST(:v AS text)", "SELECT CAST($1 AS text)", []string{"v"}},
+		{"single quotes", "SELECT ':a', 'it''s :b', :c", "SELECT ':a', 'it''s :b', $1", []string{"c"}},
+		{"double quotes", `SELECT ":a" FROM t WHERE x = :x`, `SELECT ":a" FROM t WHERE x = $1`, []string{"x"}},
+		{"escape string", `SELECT E'\':a', :b`, `SELECT E'\':a', $1`, []string{"b"}},
+		{"identifier ending in e", `SELECT somee'\', :a`, `SELECT somee'\', $1`, []string{"a"}},
+		{"line comment", "SELECT 1 -- :a\n, :b", "SELECT 1 -- :a\n, $1", []string{"b"}},
+		{"unterminated line comment", "SELECT :a -- :b", "SELECT $1 -- :b", []string{"a"}},
+		{"block comment", "SELECT /* :a */ :b", "SELECT /* :a */ $1", []string{"b"}},
+		{"dollar quotes", "SELECT $$ :a $$, :b", "SELECT $$ :a $$, $1", []string{"b"}},
+		{"tagged dollar quotes", "SELECT $fn$ :a $$ :b $fn$, :c", "SELECT $fn$ :a $$ :b $fn$, $1", []string{"c"}},
+		{"positional parameters", "SELECT $1, :a", "SELECT $1, $1", []string{"a"}},
+		{"slice with names", "SELECT arr[lo:hi] FROM t", "SELECT arr[lo:hi] FROM t", nil},
+		{"slice with number", "SELECT arr[1:n], arr[:n]", "SELECT arr[1:n], arr[:n]", nil},
+		{"slice with placeholder bound", "SELECT arr[:lo : :hi]", "SELECT arr[:lo : $1]", []string{"hi"}},
+		{"email like text", "SELECT 'a@b.c', x@y", "SELECT 'a@b.c', x@y", nil},
+		{"jsonb operators", "SELECT data @> :filter, data ? 'k'", "SELECT data @> $1, data ? 'k'", []string{"filter"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, names, err := rewriteNamedPlaceholders(test.sql, func(string, int) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("sql = %q, want %q", got, test.want)
+			}
+			if !reflect.DeepEqual(names, test.names) {
+				t.Errorf("names = %q, want %q", names, test.names)
+			}
+		})
+	}
+}
+
+func TestRewriteNamedPlaceholdersCheck(t *testing.T) {
+	errMissing := errors.New("missing")
+	var position int
+	_, _, err := rewriteNamedPlaceholders("SELECT 'ü', :missing", func(name string, pos int) error {
+		position = pos
+		return errMissing
+	})
+	if !errors.Is(err, errMissing) {
+		t.Fatalf("err = %v, want the error returned by check", err)
+	}
+	if position != 13 {
+		t.Errorf("position = %d, want 13", position)
+	}
+}
@@ -0,0 +1,91 @@
+package modpostgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/protosam/pgstar/executor/modules/starutils"
+	"go.starlark.net/starlark"
+)
+
+// LocalSettings are applied to the transaction as soon as it begins so row
+// level security policies apply before the script runs any statement
+type LocalSettings struct {
+	Role     string
+	Settings map[string]string
+}
+
+// apply sets the role and settings for the current transaction only
+func (settings *LocalSettings) apply(ctx context.Context, tx pgx.Tx) error {
+	if settings.Role != "" {
+		if _, err := tx.Exec(ctx, "SET LOCAL ROLE "+pgx.Identifier{settings.Role}.Sanitize()); err != nil {
+			return fmt.Errorf("failed to set role %s: %w", settings.Role, err)
+		}
+	}
+
+	keys := make([]string, 0, len(settings.Settings))
+	for key := range settings.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := tx.Exec(ctx, "SELECT set_config($1, $2, true)", key, settings.Settings[key]); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// asRole switches to a role for the rest of the transaction
+func (module *Module) asRole(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var role string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "role", &role); err != nil {
+		return starlark.None, err
+	}
+
+	if role == "" {
+		return starlark.None, fmt.Errorf("%s(): role must not be empty", fn.Name())
+	}
+
+	result, err := module.exec(thread, fn, starlark.Tuple{
+		starlark.String("SET LOCAL ROLE " + pgx.Identifier{role}.Sanitize()),
+	}, nil)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	return result.(*starlark.Tuple).Index(1), nil
+}
+
+// setLocal sets a configuration parameter for the rest of the transaction,
+// values that are not strings are json encoded (e.g. request.jwt.claims)
+func (module *Module) setLocal(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return starlark.None, err
+	}
+
+	setting, ok := starlark.AsString(value)
+	if !ok {
+		encoded, err := starutils.StarlarkJsonEncoder(value)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+		setting = encoded
+	}
+
+	result, err := module.exec(thread, fn, starlark.Tuple{
+		starlark.String("SELECT set_config($1, $2, true)"),
+		starlark.NewList([]starlark.Value{starlark.String(key), starlark.String(setting)}),
+	}, nil)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	return result.(*starlark.Tuple).Index(1), nil
+}
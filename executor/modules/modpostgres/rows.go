@@ -1,6 +1,7 @@
 package modpostgres
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
@@ -14,6 +15,7 @@ type Rows struct {
 	fields []string
 	rows   pgx.Rows
 	thread *starlark.Thread
+	cancel context.CancelFunc
 }
 
 func (it *Rows) Freeze() {}
@@ -32,7 +34,7 @@ func (it *Rows) Type() string {
 
 func (it *Rows) Iterate() starlark.Iterator {
 	// Implement Iterate method for the iterable
-	return &Row{module: it.module, fields: it.fields, rows: it.rows, thread: it.thread}
+	return &Row{module: it.module, fields: it.fields, rows: it.rows, thread: it.thread, cancel: it.cancel}
 }
 
 type Row struct {
@@ -40,21 +42,37 @@ type Row struct {
 	fields []string
 	rows   pgx.Rows
 	thread *starlark.Thread
+	cancel context.CancelFunc
+	err    error
 }
 
 func (it *Row) Next(p *starlark.Value) bool {
 	if it.rows.Next() {
-		values, _ := it.rows.Values()
-		*p = parseRow(values, it.fields)
+		row, err := parseRow(it.rows)
+		if err != nil {
+			it.err = err
+			it.thread.Cancel(fmt.Sprintf("failed to read db row: %s", err))
+			return false
+		}
+		*p = row
 		return true
 	}
 	return false
 }
 
+// Err reports an error that ended the iteration early
+func (it *Row) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
 func (it *Row) Done() {
 	it.rows.Close()
+	it.cancel()
 
-	if it.module.autosavepoints {
+	if it.module.autosavepoints && it.module.txstate == txActive {
 		if _, err := it.module.tx.Exec(it.module.ctx, "RELEASE SAVEPOINT "+it.module.savepointname); err != nil {
 			it.thread.Cancel(fmt.Sprintf("failed to release db savepoint: %s", err))
 		}
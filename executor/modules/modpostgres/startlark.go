@@ -1,73 +1,91 @@
 package modpostgres
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
-	"time"
 
-	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/protosam/pgstar/executor/modules/starutils"
 	"go.starlark.net/starlark"
 )
 
-func pgargsToStarlarkValue(_ *starlark.Thread, fn *starlark.Builtin, pgargs *starlark.List) []interface{} {
+// pgargsToStarlarkValue converts starlark query arguments into values pgx can bind
+func pgargsToStarlarkValue(_ *starlark.Thread, fn *starlark.Builtin, pgargs *starlark.List) ([]interface{}, error) {
 	params := []interface{}{}
 	for i := 0; i < pgargs.Len(); i++ {
-		param := pgargs.Index(i)
-		switch param.Type() {
-		case "bool":
-			params = append(params, param.Truth())
-		case "int":
-			var paramToGoVal int
-			starlark.AsInt(param, &paramToGoVal)
-			params = append(params, paramToGoVal)
-		case "float":
-			paramToGoVal, _ := starlark.AsFloat(param)
-			params = append(params, paramToGoVal)
-		case "string":
-			paramToGoVal, _ := starlark.AsString(param)
-			params = append(params, paramToGoVal)
-		default:
-			paramToGoVal, _ := starlark.AsString(param)
-			params = append(params, paramToGoVal)
-			log.Printf("failed back to Sprintf for type in %s(): %#v", fn.Name(), param)
+		param, err := starlarkToGoValue(pgargs.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("%s(): argument %d: %w", fn.Name(), i+1, err)
 		}
+		params = append(params, param)
 	}
 
-	return params
+	return params, nil
 }
 
-func parseRow(values []any, fields []string) *starlark.Dict {
-	// slvals := starlark.NewList(nil)
-	slvals := starlark.NewDict(0)
+// starlarkToGoValue converts a starlark value into a value pgx can bind as a parameter
+func starlarkToGoValue(value starlark.Value) (any, error) {
+	switch val := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(val), nil
+	case starlark.Int:
+		if i, ok := val.Int64(); ok {
+			return i, nil
+		}
+		// integers larger than 64 bits are sent as numeric
+		return pgtype.Numeric{Int: val.BigInt(), Valid: true}, nil
+	case starlark.Float:
+		return float64(val), nil
+	case starlark.String:
+		return string(val), nil
+	case starlark.Bytes:
+		return []byte(val), nil
+	case *starlark.List, starlark.Tuple:
+		// sequences are sent as postgres arrays
+		iter := val.(starlark.Iterable).Iterate()
+		defer iter.Done()
+
+		list := []any{}
+		var elem starlark.Value
+		for i := 0; iter.Next(&elem); i++ {
+			item, err := starlarkToGoValue(elem)
+			if err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case *starlark.Dict:
+		// dicts are sent as json
+		data, err := starutils.StarlarkJsonEncoder(val)
+		if err != nil {
+			return nil, err
+		}
+		return json.RawMessage(data), nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", value.Type())
+}
+
+// parseRow converts the current row into a dict keyed by field name
+func parseRow(rows pgx.Rows) (*starlark.Dict, error) {
+	values, err := rows.Values()
+	if err != nil {
+		return nil, err
+	}
+	raw := rows.RawValues()
+	fields := rows.FieldDescriptions()
+
+	slvals := starlark.NewDict(len(values))
 	for idx := range values {
-		switch values[idx].(type) {
-		case int:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.MakeInt(values[idx].(int)))
-		case string:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.String(values[idx].(string)))
-		case float32:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.Float(values[idx].(float32)))
-		case float64:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.Float(values[idx].(float64)))
-		case bool:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.Bool(values[idx].(bool)))
-		case time.Time:
-			val := values[idx].(time.Time)
-			slvals.SetKey(starlark.String(fields[idx]), starlark.String(val.String()))
-		case [16]uint8:
-			val := values[idx].([16]uint8)
-			id, _ := uuid.FromBytes(val[:])
-			slvals.SetKey(starlark.String(fields[idx]), starlark.String(id.String()))
-		case fmt.Stringer:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.String(fmt.Sprintf("%s", values[idx])))
-			log.Printf("failed back to Sprintf for type in db.rows.next(): %#v", values[idx])
-		case nil:
-			slvals.SetKey(starlark.String(fields[idx]), starlark.None)
-		default:
-			// slvals.Append(starlark.None)
-			slvals.SetKey(starlark.String(fields[idx]), starlark.None)
-			log.Printf("This type is not handled yet: %#v", values[idx])
+		value, err := columnToStarlarkValue(fields[idx], raw[idx], values[idx])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", fields[idx].Name, err)
 		}
+		slvals.SetKey(starlark.String(fields[idx].Name), value)
 	}
-	return slvals
+	return slvals, nil
 }
@@ -0,0 +1,306 @@
+package modpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/protosam/pgstar/executor/modules"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+type txState int
+
+const (
+	txActive txState = iota
+	txAborted
+	txCommitted
+	txRolledBack
+)
+
+func (state txState) String() string {
+	switch state {
+	case txActive:
+		return "active"
+	case txAborted:
+		return "aborted"
+	case txCommitted:
+		return "committed"
+	case txRolledBack:
+		return "rolled back"
+	}
+	return "unknown"
+}
+
+// usable returns an error when the transaction can no longer run statements,
+// the transaction is started if this is the first statement
+func (module *Module) usable(fn *starlark.Builtin) error {
+	switch module.txstate {
+	case txAborted:
+		return fmt.Errorf("%s(): transaction is aborted by a previous error, use db.rollbackTo() or db.savepoints(True) to recover", fn.Name())
+	case txCommitted, txRolledBack:
+		return fmt.Errorf("%s(): transaction is already %s", fn.Name(), module.txstate)
+	}
+
+	if err := module.begin(); err != nil {
+		// this is an unrecoverable system error
+		return fmt.Errorf("%s(): %w", fn.Name(), err)
+	}
+	return nil
+}
+
+// begin lazily starts the transaction so requests that never touch the
+// database do not hold a connection from the pool
+func (module *Module) begin() error {
+	if module.tx != nil {
+		return nil
+	}
+
+	// waiting for a connection is bounded by the acquire timeout
+	ctx, cancel := module.ctx, context.CancelFunc(func() {})
+	if module.acquireTimeout > 0 {
+		ctx, cancel = context.WithTimeout(module.ctx, module.acquireTimeout)
+	}
+	defer cancel()
+
+	tx, err := module.dbpool.BeginTx(ctx, module.txOptions)
+	if err != nil {
+		if module.ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%s: no database connection available after %s: %w", module.threadName, module.acquireTimeout, modules.ErrUnavailable)
+		}
+		return fmt.Errorf("%s: failed to start transaction: %w", module.threadName, err)
+	}
+
+	if module.localSettings != nil {
+		if err := module.localSettings.apply(module.ctx, tx); err != nil {
+			tx.Rollback(context.WithoutCancel(module.ctx))
+			return fmt.Errorf("%s: %w", module.threadName, err)
+		}
+	}
+	module.tx = tx
+
+	return nil
+}
+
+// failed records a failed statement, without a savepoint postgres aborts the transaction
+func (module *Module) failed(err error) {
+	if module.txstate == txActive {
+		module.txstate = txAborted
+	}
+	if isRetryable(err) && module.retryErr == nil {
+		module.retryErr = err
+	}
+}
+
+// isRetryable reports if an error is a serialization failure or deadlock
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	return false
+}
+
+func (module *Module) execSavepoint(command, name string) error {
+	_, err := module.tx.Exec(module.ctx, command+" "+pgx.Identifier{name}.Sanitize())
+	return err
+}
+
+func (module *Module) commit(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+
+	// nothing was run, so the transaction never needs to be started
+	if module.tx == nil && module.txstate == txActive {
+		module.txstate = txCommitted
+		return starlark.None, nil
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.tx.Commit(module.ctx); err != nil {
+		module.txstate = txRolledBack
+		if isRetryable(err) {
+			module.retryErr = err
+		}
+		return newError(err), nil
+	}
+	module.txstate = txCommitted
+	module.retryErr = nil
+
+	return starlark.None, nil
+}
+
+func (module *Module) rollback(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+
+	if module.txstate == txCommitted || module.txstate == txRolledBack {
+		return starlark.None, fmt.Errorf("%s(): transaction is already %s", fn.Name(), module.txstate)
+	}
+
+	if module.tx == nil {
+		module.txstate = txRolledBack
+		return starlark.None, nil
+	}
+
+	if err := module.tx.Rollback(module.ctx); err != nil {
+		// this is an unrecoverable system error
+		return starlark.None, fmt.Errorf("%s(): %s", fn.Name(), err)
+	}
+	module.txstate = txRolledBack
+
+	return starlark.None, nil
+}
+
+func (module *Module) savepoint(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.execSavepoint("SAVEPOINT", name); err != nil {
+		module.failed(err)
+		return newError(err), nil
+	}
+
+	return starlark.None, nil
+}
+
+func (module *Module) rollbackTo(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return starlark.None, err
+	}
+
+	// an aborted transaction is recoverable by rolling back to a savepoint
+	if module.txstate == txCommitted || module.txstate == txRolledBack {
+		return starlark.None, fmt.Errorf("%s(): transaction is already %s", fn.Name(), module.txstate)
+	}
+
+	if module.tx == nil {
+		return newError(fmt.Errorf("savepoint %q does not exist", name)), nil
+	}
+
+	if err := module.execSavepoint("ROLLBACK TO SAVEPOINT", name); err != nil {
+		module.failed(err)
+		return newError(err), nil
+	}
+	module.txstate = txActive
+
+	return starlark.None, nil
+}
+
+func (module *Module) release(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.execSavepoint("RELEASE SAVEPOINT", name); err != nil {
+		module.failed(err)
+		return newError(err), nil
+	}
+
+	return starlark.None, nil
+}
+
+// atomic runs a callable inside of a savepoint, all work done by the callable
+// is rolled back to the savepoint if it fails
+func (module *Module) atomic(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var callable starlark.Callable
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "fn", &callable); err != nil {
+		return starlark.None, err
+	}
+
+	if err := module.usable(fn); err != nil {
+		return starlark.None, err
+	}
+
+	module.atomicDepth++
+	defer func() { module.atomicDepth-- }()
+	name := fmt.Sprintf("%s_atomic_%d", module.savepointname, module.atomicDepth)
+
+	if err := module.execSavepoint("SAVEPOINT", name); err != nil {
+		// this is an unrecoverable system error
+		return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+	}
+
+	result, callErr := starlark.Call(thread, callable, nil, nil)
+
+	// exiting the script is not a failure of the callable
+	if callErr != nil && errors.Is(callErr, modules.ErrEarlyExit) {
+		if module.txstate == txActive {
+			if err := module.execSavepoint("RELEASE SAVEPOINT", name); err != nil {
+				return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+			}
+		}
+		return starlark.Tuple{starlark.None, starlark.None}, callErr
+	}
+
+	switch module.txstate {
+	case txCommitted, txRolledBack:
+		return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): transaction was %s inside of the callable", fn.Name(), module.txstate)
+	case txAborted:
+		if callErr == nil {
+			callErr = errors.New("transaction was aborted by a failed statement")
+		}
+	}
+
+	if callErr != nil {
+		if err := module.execSavepoint("ROLLBACK TO SAVEPOINT", name); err != nil {
+			// this is an unrecoverable system error
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+		module.txstate = txActive
+		if err := module.execSavepoint("RELEASE SAVEPOINT", name); err != nil {
+			return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+		}
+		return starlark.Tuple{starlark.None, starlark.String(fmt.Sprintf("%s", callErr))}, nil
+	}
+
+	if err := module.execSavepoint("RELEASE SAVEPOINT", name); err != nil {
+		return starlark.Tuple{starlark.None, starlark.None}, fmt.Errorf("%s(): %s", fn.Name(), err)
+	}
+
+	return starlark.Tuple{result, starlark.None}, nil
+}
+
+// txOptionsFn exposes the transaction options configured for the route
+func (module *Module) txOptionsFn(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return starlark.None, err
+	}
+
+	var isolation, access starlark.Value = starlark.None, starlark.None
+	if module.txOptions.IsoLevel != "" {
+		isolation = starlark.String(module.txOptions.IsoLevel)
+	}
+	if module.txOptions.AccessMode != "" {
+		access = starlark.String(module.txOptions.AccessMode)
+	}
+
+	return starlarkstruct.FromStringDict(
+		starlark.String("db.txOptions"),
+		starlark.StringDict{
+			"isolation":  isolation,
+			"access":     access,
+			"deferrable": starlark.Bool(module.txOptions.DeferrableMode == pgx.Deferrable),
+		},
+	), nil
+}
@@ -0,0 +1,221 @@
+package modpostgres
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/protosam/pgstar/executor/modules/starutils"
+	"go.starlark.net/starlark"
+)
+
+// TypeMapper converts a value decoded by pgx into a starlark value
+type TypeMapper func(value any) (starlark.Value, error)
+
+var typeMappersMu sync.RWMutex
+var oidMappers = map[uint32]TypeMapper{}
+var goTypeMappers = map[reflect.Type]TypeMapper{}
+
+// RegisterOIDMapper adds a mapping for columns with the given postgres type OID,
+// OID mappings take precedence over all other mappings
+func RegisterOIDMapper(oid uint32, mapper TypeMapper) {
+	typeMappersMu.Lock()
+	defer typeMappersMu.Unlock()
+	oidMappers[oid] = mapper
+}
+
+// RegisterTypeMapper adds a mapping for values that pgx decodes into the same
+// Go type as sample, this also applies to values nested in arrays and ranges
+func RegisterTypeMapper(sample any, mapper TypeMapper) {
+	typeMappersMu.Lock()
+	defer typeMappersMu.Unlock()
+	goTypeMappers[reflect.TypeOf(sample)] = mapper
+}
+
+func lookupOIDMapper(oid uint32) (TypeMapper, bool) {
+	typeMappersMu.RLock()
+	defer typeMappersMu.RUnlock()
+	mapper, ok := oidMappers[oid]
+	return mapper, ok
+}
+
+func lookupTypeMapper(value any) (TypeMapper, bool) {
+	typeMappersMu.RLock()
+	defer typeMappersMu.RUnlock()
+	mapper, ok := goTypeMappers[reflect.TypeOf(value)]
+	return mapper, ok
+}
+
+// columnToStarlarkValue converts a column value, the raw value is used for
+// json so numbers are decoded without a round trip through float64
+func columnToStarlarkValue(field pgconn.FieldDescription, raw []byte, value any) (starlark.Value, error) {
+	if mapper, ok := lookupOIDMapper(field.DataTypeOID); ok {
+		return mapper(value)
+	}
+
+	if value == nil {
+		return starlark.None, nil
+	}
+
+	switch field.DataTypeOID {
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		// binary jsonb is prefixed with a version number
+		if field.DataTypeOID == pgtype.JSONBOID && field.Format == pgtype.BinaryFormatCode && len(raw) > 0 {
+			raw = raw[1:]
+		}
+		return starutils.StarlarkJsonDecoder(string(raw), nil)
+	case pgtype.DateOID:
+		if val, ok := value.(time.Time); ok {
+			return starlark.String(val.Format(time.DateOnly)), nil
+		}
+	}
+
+	return toStarlarkValue(value)
+}
+
+// toStarlarkValue converts a value decoded by pgx into a starlark value
+func toStarlarkValue(value any) (starlark.Value, error) {
+	if mapper, ok := lookupTypeMapper(value); ok {
+		return mapper(value)
+	}
+
+	switch val := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(val), nil
+	case string:
+		return starlark.String(val), nil
+	case int:
+		return starlark.MakeInt(val), nil
+	case int8:
+		return starlark.MakeInt64(int64(val)), nil
+	case int16:
+		return starlark.MakeInt64(int64(val)), nil
+	case int32:
+		return starlark.MakeInt64(int64(val)), nil
+	case int64:
+		return starlark.MakeInt64(val), nil
+	case uint8:
+		return starlark.MakeUint64(uint64(val)), nil
+	case uint16:
+		return starlark.MakeUint64(uint64(val)), nil
+	case uint32:
+		return starlark.MakeUint64(uint64(val)), nil
+	case uint64:
+		return starlark.MakeUint64(val), nil
+	case *big.Int:
+		return starlark.MakeBigInt(val), nil
+	case float32:
+		return starlark.Float(val), nil
+	case float64:
+		return starlark.Float(val), nil
+	case []byte:
+		return starlark.Bytes(val), nil
+	case time.Time:
+		return starlark.String(val.Format(time.RFC3339Nano)), nil
+	case [16]uint8:
+		id, _ := uuid.FromBytes(val[:])
+		return starlark.String(id.String()), nil
+	case pgtype.Numeric:
+		// numeric is kept as a string to avoid losing precision
+		return valuerToStarlarkValue(val)
+	case pgtype.Range[any]:
+		return rangeToStarlarkValue(val)
+	case pgtype.Multirange[pgtype.Range[any]]:
+		list := make([]starlark.Value, 0, len(val))
+		for i := range val {
+			item, err := rangeToStarlarkValue(val[i])
+			if err != nil {
+				return starlark.None, err
+			}
+			list = append(list, item)
+		}
+		return starlark.NewList(list), nil
+	case []any:
+		list := make([]starlark.Value, 0, len(val))
+		for i := range val {
+			item, err := toStarlarkValue(val[i])
+			if err != nil {
+				return starlark.None, fmt.Errorf("at index %d: %w", i, err)
+			}
+			list = append(list, item)
+		}
+		return starlark.NewList(list), nil
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		dict := starlark.NewDict(len(val))
+		for _, key := range keys {
+			item, err := toStarlarkValue(val[key])
+			if err != nil {
+				return starlark.None, fmt.Errorf("in key %s: %w", key, err)
+			}
+			dict.SetKey(starlark.String(key), item)
+		}
+		return dict, nil
+	case driver.Valuer:
+		// most pgtype values (interval, time, geometric types) have a text form
+		return valuerToStarlarkValue(val)
+	case fmt.Stringer:
+		return starlark.String(val.String()), nil
+	}
+
+	return starlark.None, fmt.Errorf("unsupported postgres value of type %T, register a mapping with RegisterTypeMapper", value)
+}
+
+func valuerToStarlarkValue(valuer driver.Valuer) (starlark.Value, error) {
+	value, err := valuer.Value()
+	if err != nil {
+		return starlark.None, err
+	}
+	return toStarlarkValue(value)
+}
+
+func rangeToStarlarkValue(val pgtype.Range[any]) (starlark.Value, error) {
+	if !val.Valid {
+		return starlark.None, nil
+	}
+
+	lower, err := toStarlarkValue(val.Lower)
+	if err != nil {
+		return starlark.None, err
+	}
+	upper, err := toStarlarkValue(val.Upper)
+	if err != nil {
+		return starlark.None, err
+	}
+
+	dict := starlark.NewDict(5)
+	dict.SetKey(starlark.String("lower"), lower)
+	dict.SetKey(starlark.String("upper"), upper)
+	dict.SetKey(starlark.String("lowerBound"), starlark.String(boundTypeName(val.LowerType)))
+	dict.SetKey(starlark.String("upperBound"), starlark.String(boundTypeName(val.UpperType)))
+	dict.SetKey(starlark.String("empty"), starlark.Bool(val.LowerType == pgtype.Empty))
+	return dict, nil
+}
+
+func boundTypeName(boundType pgtype.BoundType) string {
+	switch boundType {
+	case pgtype.Inclusive:
+		return "inclusive"
+	case pgtype.Exclusive:
+		return "exclusive"
+	case pgtype.Unbounded:
+		return "unbounded"
+	case pgtype.Empty:
+		return "empty"
+	}
+	return string(boundType)
+}
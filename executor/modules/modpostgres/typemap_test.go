@@ -0,0 +1,164 @@
+package modpostgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.starlark.net/starlark"
+)
+
+func TestToStarlarkValue(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"null", nil, "None"},
+		{"bool", true, "True"},
+		{"text", "hello", `"hello"`},
+		{"int2", int16(-7), "-7"},
+		{"int4", int32(42), "42"},
+		{"int8", int64(9007199254740993), "9007199254740993"},
+		{"oid", uint32(4294967295), "4294967295"},
+		{"big int", huge, "123456789012345678901234567890"},
+		{"float4", float32(1.5), "1.5"},
+		{"float8", 0.1, "0.1"},
+		{"bytea", []byte{0xff, 0x00}, `b"\xff\x00"`},
+		{"timestamptz", time.Date(2024, 2, 29, 13, 4, 5, 600000000, time.UTC), `"2024-02-29T13:04:05.6Z"`},
+		{"timestamp offset", time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 2*3600)), `"2024-01-02T03:04:05+02:00"`},
+		{"uuid", [16]uint8{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}, `"123e4567-e89b-12d3-a456-426614174000"`},
+		{"numeric", pgtype.Numeric{Int: big.NewInt(12345), Exp: -2, Valid: true}, `"123.45"`},
+		{"numeric null", pgtype.Numeric{}, "None"},
+		{"numeric nan", pgtype.Numeric{NaN: true, Valid: true}, `"NaN"`},
+		{"interval", pgtype.Interval{Months: 1, Days: 2, Microseconds: 3600000000, Valid: true}, `"1 mon 2 day 01:00:00"`},
+		{"int array", []any{int32(1), nil, int32(3)}, "[1, None, 3]"},
+		{"nested array", []any{[]any{"a"}, []any{"b"}}, `[["a"], ["b"]]`},
+		{"json object", map[string]any{"b": float64(2), "a": []any{true}}, `{"a": [True], "b": 2.0}`},
+		{"range", pgtype.Range[any]{Lower: int32(1), Upper: int32(10), LowerType: pgtype.Inclusive, UpperType: pgtype.Exclusive, Valid: true},
+			`{"lower": 1, "upper": 10, "lowerBound": "inclusive", "upperBound": "exclusive", "empty": False}`},
+		{"empty range", pgtype.Range[any]{LowerType: pgtype.Empty, UpperType: pgtype.Empty, Valid: true},
+			`{"lower": None, "upper": None, "lowerBound": "empty", "upperBound": "empty", "empty": True}`},
+		{"null range", pgtype.Range[any]{}, "None"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := toStarlarkValue(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("got %s, want %s", got.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestToStarlarkValueUnsupported(t *testing.T) {
+	if _, err := toStarlarkValue(struct{}{}); err == nil {
+		t.Fatal("expected an error for an unsupported type")
+	}
+}
+
+func TestColumnToStarlarkValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		field pgconn.FieldDescription
+		raw   []byte
+		value any
+		want  string
+	}{
+		{"json keeps big numbers", pgconn.FieldDescription{DataTypeOID: pgtype.JSONOID}, []byte(`{"id": 9007199254740993}`), map[string]any{}, `{"id": 9007199254740993}`},
+		{"jsonb text", pgconn.FieldDescription{DataTypeOID: pgtype.JSONBOID, Format: pgtype.TextFormatCode}, []byte(`[1, "a"]`), []any{}, `[1, "a"]`},
+		{"jsonb binary", pgconn.FieldDescription{DataTypeOID: pgtype.JSONBOID, Format: pgtype.BinaryFormatCode}, append([]byte{1}, `{"a": null}`...), map[string]any{}, `{"a": None}`},
+		{"json null", pgconn.FieldDescription{DataTypeOID: pgtype.JSONBOID}, nil, nil, "None"},
+		{"date", pgconn.FieldDescription{DataTypeOID: pgtype.DateOID}, nil, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), `"2024-02-29"`},
+		{"text", pgconn.FieldDescription{DataTypeOID: pgtype.TextOID}, []byte("x"), "x", `"x"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := columnToStarlarkValue(test.field, test.raw, test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("got %s, want %s", got.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestRegisterOIDMapper(t *testing.T) {
+	const oid = 999999
+	RegisterOIDMapper(oid, func(value any) (starlark.Value, error) {
+		return starlark.String("mapped"), nil
+	})
+	defer func() {
+		typeMappersMu.Lock()
+		delete(oidMappers, oid)
+		typeMappersMu.Unlock()
+	}()
+
+	got, err := columnToStarlarkValue(pgconn.FieldDescription{DataTypeOID: oid}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != starlark.String("mapped") {
+		t.Errorf("got %s, want the registered mapping", got)
+	}
+}
+
+func TestStarlarkToGoValue(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	dict := starlark.NewDict(1)
+	dict.SetKey(starlark.String("a"), starlark.MakeInt(1))
+
+	tests := []struct {
+		name  string
+		value starlark.Value
+		want  any
+	}{
+		{"none", starlark.None, nil},
+		{"bool", starlark.True, true},
+		{"int", starlark.MakeInt(42), int64(42)},
+		{"big int", starlark.MakeBigInt(huge), pgtype.Numeric{Int: huge, Valid: true}},
+		{"float", starlark.Float(1.5), 1.5},
+		{"string", starlark.String("x"), "x"},
+		{"bytes", starlark.Bytes("\x00\x01"), []byte{0, 1}},
+		{"list", starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.None}), []any{int64(1), nil}},
+		{"tuple", starlark.Tuple{starlark.String("a"), starlark.Tuple{starlark.True}}, []any{"a", []any{true}}},
+		{"dict", dict, json.RawMessage(`{"a":1}`)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := starlarkToGoValue(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotJSON, wantJSON := mustJSON(t, got), mustJSON(t, test.want); gotJSON != wantJSON || fmt.Sprintf("%T", got) != fmt.Sprintf("%T", test.want) {
+				t.Errorf("got %T %s, want %T %s", got, gotJSON, test.want, wantJSON)
+			}
+		})
+	}
+
+	if _, err := starlarkToGoValue(starlark.NewSet(0)); err == nil {
+		t.Error("expected an error for a set")
+	}
+}
+
+func mustJSON(t *testing.T, value any) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,244 @@
+package modvalidate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/protosam/pgstar/executor/modules"
+	"github.com/protosam/pgstar/executor/modules/starutils"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const ModuleName = "validate"
+
+// Failure is a value that does not match the schema, Pointer is the JSON
+// pointer of the value, "" for the value itself
+type Failure struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// Validator is a compiled JSON Schema
+type Validator struct {
+	schema *jsonschema.Schema
+
+	// Document is the decoded schema, e.g. for generating documentation
+	Document any
+}
+
+// Compile compiles a decoded JSON Schema, name identifies it in errors
+func Compile(name string, document any) (*Validator, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	url := "pgstar:///" + neturl.PathEscape(name)
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{schema: schema, Document: document}, nil
+}
+
+// CompileFile compiles a JSON Schema file, $ref can point at files relative to it
+func CompileFile(path string) (*Validator, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// the file is compiled from the data already read, its url keeps
+	// relative references resolving next to it
+	url := (&neturl.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{schema: schema, Document: document}, nil
+}
+
+// Validate returns the failures of value, values must be decoded JSON
+func (v *Validator) Validate(value any) []Failure {
+	err := v.schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return []Failure{{Message: err.Error()}}
+	}
+
+	// only the innermost errors describe what is actually wrong
+	var failures []Failure
+	var collect func(*jsonschema.ValidationError)
+	collect = func(verr *jsonschema.ValidationError) {
+		if len(verr.Causes) == 0 {
+			failures = append(failures, Failure{Pointer: verr.InstanceLocation, Message: verr.Message})
+			return
+		}
+		for _, cause := range verr.Causes {
+			collect(cause)
+		}
+	}
+	collect(verr)
+
+	return failures
+}
+
+// Coerce converts string values such as query parameters into the types
+// declared by the top level properties of the schema, values that do not
+// convert are left alone so validation reports them. Values are a list when
+// the property is an array and a single string otherwise.
+func (v *Validator) Coerce(values map[string][]string) map[string]any {
+	properties := map[string]any{}
+	if document, ok := v.Document.(map[string]any); ok {
+		properties, _ = document["properties"].(map[string]any)
+	}
+
+	coerced := make(map[string]any, len(values))
+	for name, strs := range values {
+		property, _ := properties[name].(map[string]any)
+		if property["type"] == "array" {
+			items, _ := property["items"].(map[string]any)
+			list := make([]any, len(strs))
+			for i, s := range strs {
+				list[i] = coerce(s, items["type"])
+			}
+			coerced[name] = list
+			continue
+		}
+		if len(strs) > 0 {
+			coerced[name] = coerce(strs[0], property["type"])
+		}
+	}
+	return coerced
+}
+
+func coerce(s string, typ any) any {
+	switch typ {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(s)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+// ToJSONValue converts a starlark value into decoded JSON for validation
+func ToJSONValue(value starlark.Value) (any, error) {
+	encoded, err := starutils.StarlarkJsonEncoder(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(encoded))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+type Module struct{}
+
+func Constructor(loader modules.ModuleLoader) (modules.LocalizedModule, error) {
+	return &Module{}, nil
+}
+
+func (module *Module) Exports() starlark.StringDict {
+	return starlark.StringDict{
+		"exports": starlarkstruct.FromStringDict(
+			starlark.String(ModuleName),
+			starlark.StringDict{
+				"compile": starlark.NewBuiltin("validate.compile", module.compile),
+			},
+		),
+	}
+}
+
+func (module *Module) Destroy(loader modules.ModuleLoader, outcome modules.Outcome) error { return nil }
+
+func (module *Module) Name() string {
+	return ModuleName
+}
+
+// compile returns a validator for a schema dict
+func (module *Module) compile(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var slschema *starlark.Dict
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "schema", &slschema); err != nil {
+		return starlark.None, err
+	}
+
+	document, err := ToJSONValue(slschema)
+	if err != nil {
+		return starlark.Tuple{starlark.None, starlark.String(err.Error())}, nil
+	}
+	validator, err := Compile(thread.Name, document)
+	if err != nil {
+		return starlark.Tuple{starlark.None, starlark.String(err.Error())}, nil
+	}
+
+	return starlark.Tuple{starlarkstruct.FromStringDict(
+		starlark.String("validate.validator"),
+		starlark.StringDict{
+			"validate": starlark.NewBuiltin("validator.validate", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				var value starlark.Value
+				if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &value); err != nil {
+					return starlark.None, err
+				}
+				decoded, err := ToJSONValue(value)
+				if err != nil {
+					return starlark.None, fmt.Errorf("%s(): %w", fn.Name(), err)
+				}
+
+				failures := validator.Validate(decoded)
+				list := make([]starlark.Value, 0, len(failures))
+				for _, failure := range failures {
+					list = append(list, starlarkstruct.FromStringDict(
+						starlark.String("validate.failure"),
+						starlark.StringDict{
+							"pointer": starlark.String(failure.Pointer),
+							"message": starlark.String(failure.Message),
+						},
+					))
+				}
+				return starlark.NewList(list), nil
+			}),
+		},
+	), starlark.None}, nil
+}
@@ -0,0 +1,124 @@
+package modvalidate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCoerce(t *testing.T) {
+	validator, err := Compile("query", map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"limit":  map[string]any{"type": "integer"},
+			"ratio":  map[string]any{"type": "number"},
+			"active": map[string]any{"type": "boolean"},
+			"ids":    map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			"tags":   map[string]any{"type": "array"},
+			"name":   map[string]any{"type": "string"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values map[string][]string
+		want   map[string]any
+	}{
+		{"integer", map[string][]string{"limit": {"10"}}, map[string]any{"limit": json.Number("10")}},
+		{"number", map[string][]string{"ratio": {"0.5"}}, map[string]any{"ratio": json.Number("0.5")}},
+		{"boolean", map[string][]string{"active": {"true"}}, map[string]any{"active": true}},
+		{"string", map[string][]string{"name": {"10"}}, map[string]any{"name": "10"}},
+		{"first value of a scalar", map[string][]string{"limit": {"1", "2"}}, map[string]any{"limit": json.Number("1")}},
+		{"array items", map[string][]string{"ids": {"1", "2"}}, map[string]any{"ids": []any{json.Number("1"), json.Number("2")}}},
+		{"untyped array items", map[string][]string{"tags": {"a"}}, map[string]any{"tags": []any{"a"}}},
+		{"invalid values are kept", map[string][]string{"limit": {"ten"}, "active": {"maybe"}}, map[string]any{"limit": "ten", "active": "maybe"}},
+		{"unknown property", map[string][]string{"other": {"1"}}, map[string]any{"other": "1"}},
+		{"empty values", map[string][]string{"limit": {}}, map[string]any{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validator.Coerce(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Coerce() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	validator, err := Compile("body", map[string]any{
+		"type":     "object",
+		"required": []any{"email"},
+		"properties": map[string]any{
+			"email": map[string]any{"type": "string"},
+			"age":   map[string]any{"type": "integer", "minimum": 0},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    any
+		pointers []string
+	}{
+		{"valid", map[string]any{"email": "a@example.com", "age": json.Number("3")}, nil},
+		{"missing required", map[string]any{}, []string{""}},
+		{"wrong type", map[string]any{"email": 1}, []string{"/email"}},
+		{"below minimum", map[string]any{"email": "a@example.com", "age": json.Number("-1")}, []string{"/age"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pointers []string
+			for _, failure := range validator.Validate(tt.value) {
+				pointers = append(pointers, failure.Pointer)
+			}
+			if !reflect.DeepEqual(pointers, tt.pointers) {
+				t.Errorf("failure pointers = %q, want %q", pointers, tt.pointers)
+			}
+		})
+	}
+}
+
+func TestCompileFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"user.json":         `{"type": "object", "properties": {"address": {"$ref": "defs/address.json"}}}`,
+		"defs/address.json": `{"type": "object", "required": ["city"]}`,
+		"invalid.json":      `{"type": `,
+		"missing_ref.json":  `{"$ref": "defs/missing.json"}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	validator, err := CompileFile(filepath.Join(dir, "user.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := validator.Document.(map[string]any)["properties"]; !ok {
+		t.Errorf("document was not decoded: %#v", validator.Document)
+	}
+	if failures := validator.Validate(map[string]any{"address": map[string]any{}}); len(failures) != 1 || failures[0].Pointer != "/address" {
+		t.Errorf("relative $ref was not applied: %+v", failures)
+	}
+
+	for _, name := range []string{"invalid.json", "missing_ref.json", "absent.json"} {
+		if _, err := CompileFile(filepath.Join(dir, name)); err == nil {
+			t.Errorf("CompileFile(%s) did not fail", name)
+		}
+	}
+}
@@ -1,25 +1,116 @@
 package modules
 
 import (
+	"context"
 	"errors"
 
 	"go.starlark.net/starlark"
 )
 
 var ErrEarlyExit = errors.New("EXIT CALLED BY SCRIPT")
+var ErrStateNotFound = errors.New("state not found")
+
+// ErrRetryable is returned by module Destroy functions when the script can be
+// executed again, e.g. after a serialization failure
+var ErrRetryable = errors.New("execution can be retried")
+
+// ErrUnavailable is returned when a resource needed by the script could not
+// be obtained in time, e.g. a database connection, clients get a 503
+var ErrUnavailable = errors.New("service unavailable")
+
+// EarlyExit is returned by builtins that end the script with a response status
+type EarlyExit struct {
+	StatusCode int
+}
+
+func (e *EarlyExit) Error() string {
+	return ErrEarlyExit.Error()
+}
+
+func (e *EarlyExit) Unwrap() error {
+	return ErrEarlyExit
+}
+
+// ExitState describes how a script execution ended
+type ExitState int
+
+const (
+	ExitSuccess ExitState = iota
+	ExitEarly
+	ExitError
+	ExitCancelled
+)
+
+func (state ExitState) String() string {
+	switch state {
+	case ExitSuccess:
+		return "success"
+	case ExitEarly:
+		return "early exit"
+	case ExitError:
+		return "error"
+	case ExitCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// Outcome is passed to modules when they are destroyed so they can decide how
+// to finalize their work (e.g. commit or rollback)
+type Outcome struct {
+	State      ExitState
+	StatusCode int
+	Err        error
+}
+
+// NewOutcome classifies the error returned from executing a script
+func NewOutcome(err error) Outcome {
+	outcome := Outcome{State: ExitSuccess, Err: err}
+
+	var earlyExit *EarlyExit
+	switch {
+	case err == nil:
+		return outcome
+	case errors.As(err, &earlyExit):
+		outcome.State = ExitEarly
+		outcome.StatusCode = earlyExit.StatusCode
+	case errors.Is(err, ErrEarlyExit):
+		outcome.State = ExitEarly
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		outcome.State = ExitCancelled
+	default:
+		outcome.State = ExitError
+	}
+
+	return outcome
+}
+
+// Succeeded reports if the execution should be treated as successful, early
+// exits with a 4xx or 5xx status code are not considered successful unless
+// commitOnErrorStatus is set
+func (outcome Outcome) Succeeded(commitOnErrorStatus bool) bool {
+	switch outcome.State {
+	case ExitSuccess:
+		return true
+	case ExitEarly:
+		return outcome.StatusCode < 400 || commitOnErrorStatus
+	}
+	return false
+}
 
 // Used to expose a module loader to modules for consumption
 type ModuleLoader interface {
 	SetState(string, interface{}) error
 	GetState(string, interface{}) error
 	GetThreadName() string
+	LoadModule(string) (starlark.StringDict, error)
 }
 
 // Used by module loaders to orchestrate the use of a module
 type LocalizedModule interface {
 	Name() string
 	Exports() starlark.StringDict
-	Destroy(ModuleLoader) error
+	Destroy(ModuleLoader, Outcome) error
 }
 
 type ModuleExporterFn func(loader ModuleLoader) (LocalizedModule, error)
@@ -0,0 +1,46 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewOutcome(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		state      ExitState
+		statusCode int
+		succeeded  bool
+		onError    bool
+	}{
+		{"success", nil, ExitSuccess, 0, true, true},
+		{"early exit ok", &EarlyExit{StatusCode: 201}, ExitEarly, 201, true, true},
+		{"early exit client error", &EarlyExit{StatusCode: 404}, ExitEarly, 404, false, true},
+		{"early exit server error", fmt.Errorf("wrapped: %w", &EarlyExit{StatusCode: 500}), ExitEarly, 500, false, true},
+		{"early exit without status", ErrEarlyExit, ExitEarly, 0, true, true},
+		{"cancelled", fmt.Errorf("db.query(): %w", context.Canceled), ExitCancelled, 0, false, false},
+		{"deadline", fmt.Errorf("%w: failed", context.DeadlineExceeded), ExitCancelled, 0, false, false},
+		{"error", errors.New("boom"), ExitError, 0, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outcome := NewOutcome(test.err)
+			if outcome.State != test.state {
+				t.Errorf("state = %s, want %s", outcome.State, test.state)
+			}
+			if outcome.StatusCode != test.statusCode {
+				t.Errorf("status code = %d, want %d", outcome.StatusCode, test.statusCode)
+			}
+			if got := outcome.Succeeded(false); got != test.succeeded {
+				t.Errorf("Succeeded(false) = %t, want %t", got, test.succeeded)
+			}
+			if got := outcome.Succeeded(true); got != test.onError {
+				t.Errorf("Succeeded(true) = %t, want %t", got, test.onError)
+			}
+		})
+	}
+}
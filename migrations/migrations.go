@@ -0,0 +1,348 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultTable records the applied migrations
+const DefaultTable = "pgstar_schema_migrations"
+
+// lockKey is the advisory lock held while migrations run so concurrent
+// deploys wait for each other instead of racing
+const lockKey int64 = 0x70677374617200 // "pgstar"
+
+var filenamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Migration is a versioned pair of up and down sql files
+type Migration struct {
+	Version  int64
+	Name     string
+	UpFile   string
+	DownFile string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Applied is a migration recorded in the tracking table
+type Applied struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Status describes a migration found in the directory or the tracking table
+type Status struct {
+	Version   int64
+	Name      string
+	State     string
+	AppliedAt time.Time
+}
+
+const (
+	StatePending = "pending"
+	StateApplied = "applied"
+	StateChanged = "changed"
+	StateMissing = "missing"
+)
+
+// Load reads the migrations in dir ordered by version, files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql
+func Load(dir string) ([]*Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := filenamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version: %w", entry.Name(), err)
+		}
+		path := filepath.Join(dir, entry.Name())
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is already used by %s", entry.Name(), version, migration.Name)
+		}
+
+		if match[3] == "up" {
+			migration.UpFile = path
+			migration.Up = string(contents)
+			sum := sha256.Sum256(contents)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.DownFile = path
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.UpFile == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Create writes empty up and down files for a new migration versioned by the
+// current time and returns their paths
+func Create(dir, name string, now time.Time) (string, string, error) {
+	if !namePattern.MatchString(name) {
+		return "", "", fmt.Errorf("migration name may only contain letters, digits and underscores")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Join(dir, now.UTC().Format("20060102150405")+"_"+name)
+	up, down := base+".up.sql", base+".down.sql"
+	for _, path := range []string{up, down} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		f.Close()
+	}
+
+	return up, down, nil
+}
+
+// Migrator runs migrations on a single connection
+type Migrator struct {
+	Conn  *pgx.Conn
+	Table string
+	Log   func(format string, args ...any)
+}
+
+func (m *Migrator) logf(format string, args ...any) {
+	if m.Log != nil {
+		m.Log(format, args...)
+	}
+}
+
+func (m *Migrator) table() string {
+	table := m.Table
+	if table == "" {
+		table = DefaultTable
+	}
+	return pgx.Identifier{table}.Sanitize()
+}
+
+// lock takes the advisory lock and ensures the tracking table exists, the
+// returned function releases the lock
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if _, err := m.Conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return nil, fmt.Errorf("unable to take migration lock: %w", err)
+	}
+	unlock := func() {
+		m.Conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockKey)
+	}
+
+	if _, err := m.Conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+m.table()+` (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	checksum text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`); err != nil {
+		unlock()
+		return nil, fmt.Errorf("unable to create migration table: %w", err)
+	}
+
+	return unlock, nil
+}
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied(ctx context.Context) (map[int64]Applied, error) {
+	rows, err := m.Conn.Query(ctx, "SELECT version, name, checksum, applied_at FROM "+m.table())
+	if err != nil {
+		return nil, err
+	}
+	records, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Applied, error) {
+		var a Applied
+		err := row.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt)
+		return a, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]Applied, len(records))
+	for _, a := range records {
+		applied[a.Version] = a
+	}
+	return applied, nil
+}
+
+// Up applies pending migrations in order, each in its own transaction. A
+// changed checksum of an applied migration stops the run before anything is
+// applied. limit bounds the number of migrations applied, 0 applies all.
+func (m *Migrator) Up(ctx context.Context, migrations []*Migration, limit int) (int, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []*Migration
+	for _, migration := range migrations {
+		record, ok := applied[migration.Version]
+		if !ok {
+			pending = append(pending, migration)
+			continue
+		}
+		if record.Checksum != migration.Checksum {
+			return 0, fmt.Errorf("migration %d_%s was changed after it was applied", migration.Version, migration.Name)
+		}
+	}
+	if limit > 0 && len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	for i, migration := range pending {
+		err := pgx.BeginFunc(ctx, m.Conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migration.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO "+m.table()+" (version, name, checksum) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, migration.Checksum)
+			return err
+		})
+		if err != nil {
+			return i, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		m.logf("applied %d_%s", migration.Version, migration.Name)
+	}
+
+	return len(pending), nil
+}
+
+// Down reverts the last steps applied migrations in reverse order, each in
+// its own transaction
+func (m *Migrator) Down(ctx context.Context, migrations []*Migration, steps int) (int, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	files := map[int64]*Migration{}
+	for _, migration := range migrations {
+		files[migration.Version] = migration
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if steps > 0 && len(versions) > steps {
+		versions = versions[:steps]
+	}
+
+	for i, version := range versions {
+		migration, ok := files[version]
+		if !ok {
+			return i, fmt.Errorf("migration %d_%s has no files in the migration directory", version, applied[version].Name)
+		}
+		if migration.DownFile == "" {
+			return i, fmt.Errorf("migration %d_%s has no down file", version, migration.Name)
+		}
+
+		err := pgx.BeginFunc(ctx, m.Conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migration.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "DELETE FROM "+m.table()+" WHERE version = $1", version)
+			return err
+		})
+		if err != nil {
+			return i, fmt.Errorf("migration %d_%s failed to revert: %w", version, migration.Name, err)
+		}
+		m.logf("reverted %d_%s", version, migration.Name)
+	}
+
+	return len(versions), nil
+}
+
+// Status compares the migration directory with the tracking table, it only
+// reads so every migration is pending while the tracking table does not exist
+func (m *Migrator) Status(ctx context.Context, migrations []*Migration) ([]Status, error) {
+	var exists bool
+	if err := m.Conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", m.table()).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]Applied{}
+	if exists {
+		var err error
+		if applied, err = m.applied(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []Status
+	for _, migration := range migrations {
+		status := Status{Version: migration.Version, Name: migration.Name, State: StatePending}
+		if record, ok := applied[migration.Version]; ok {
+			status.State = StateApplied
+			status.AppliedAt = record.AppliedAt
+			if record.Checksum != migration.Checksum {
+				status.State = StateChanged
+			}
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	// applied migrations whose files were removed
+	for _, record := range applied {
+		statuses = append(statuses, Status{Version: record.Version, Name: record.Name, State: StateMissing, AppliedAt: record.AppliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
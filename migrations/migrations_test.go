@@ -0,0 +1,137 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		versions []int64
+		err      string
+	}{
+		{
+			name: "ordered by version",
+			files: map[string]string{
+				"10_third.up.sql":  "SELECT 3",
+				"2_second.up.sql":  "SELECT 2",
+				"1_first.up.sql":   "SELECT 1",
+				"1_first.down.sql": "SELECT -1",
+			},
+			versions: []int64{1, 2, 10},
+		},
+		{
+			name: "unrelated files are ignored",
+			files: map[string]string{
+				"1_first.up.sql": "SELECT 1",
+				"README.md":      "notes",
+				"seed.sql":       "SELECT 0",
+			},
+			versions: []int64{1},
+		},
+		{
+			name:     "empty directory",
+			files:    map[string]string{},
+			versions: []int64{},
+		},
+		{
+			name: "duplicate version",
+			files: map[string]string{
+				"1_first.up.sql": "SELECT 1",
+				"1_other.up.sql": "SELECT 1",
+			},
+			err: "version 1 is already used",
+		},
+		{
+			name: "missing up file",
+			files: map[string]string{
+				"1_first.down.sql": "SELECT -1",
+			},
+			err: "has no up file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(writeFiles(t, tt.files))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			versions := []int64{}
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			if len(versions) != len(tt.versions) {
+				t.Fatalf("versions = %v, want %v", versions, tt.versions)
+			}
+			for i := range versions {
+				if versions[i] != tt.versions[i] {
+					t.Fatalf("versions = %v, want %v", versions, tt.versions)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadChecksum(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"1_first.up.sql":   "CREATE TABLE a (id int)",
+		"1_first.down.sql": "DROP TABLE a",
+	})
+
+	migrations, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("CREATE TABLE a (id int)"))
+	if got, want := migrations[0].Checksum, hex.EncodeToString(sum[:]); got != want {
+		t.Fatalf("checksum = %s, want %s", got, want)
+	}
+
+	// only the up file is covered by the checksum
+	if err := os.WriteFile(filepath.Join(dir, "1_first.down.sql"), []byte("DROP TABLE IF EXISTS a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changedDown, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changedDown[0].Checksum != migrations[0].Checksum {
+		t.Error("checksum changed with the down file")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "1_first.up.sql"), []byte("CREATE TABLE a (id bigint)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changedUp, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changedUp[0].Checksum == migrations[0].Checksum {
+		t.Error("checksum did not change with the up file")
+	}
+}
@@ -0,0 +1,167 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// testConn connects to the test database with a scratch schema as its
+// search path, the schema is dropped when the test ends
+func testConn(t *testing.T) *pgx.Conn {
+	t.Helper()
+	config := os.Getenv("PGSTAR_TEST_POSTGRES_CONFIG")
+	if config == "" {
+		t.Skip("PGSTAR_TEST_POSTGRES_CONFIG is not set")
+	}
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(ctx) })
+
+	schema := pgx.Identifier{"pgstar_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")}.Sanitize()
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE") })
+	if _, err := conn.Exec(ctx, "SET search_path TO "+schema); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func states(t *testing.T, m *Migrator, migrations []*Migration) []string {
+	t.Helper()
+	statuses, err := m.Status(context.Background(), migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for _, status := range statuses {
+		states = append(states, status.State)
+	}
+	return states
+}
+
+func tableExists(t *testing.T, conn *pgx.Conn, table string) bool {
+	t.Helper()
+	var exists bool
+	if err := conn.QueryRow(context.Background(), "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestMigrator(t *testing.T) {
+	conn := testConn(t)
+	ctx := context.Background()
+	migrations, err := Load(writeFiles(t, map[string]string{
+		"1_users.up.sql":   "CREATE TABLE users (id int)",
+		"1_users.down.sql": "DROP TABLE users",
+		"2_posts.up.sql":   "CREATE TABLE posts (id int)",
+		"2_posts.down.sql": "DROP TABLE posts",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{Conn: conn}
+
+	// status does not create the tracking table
+	if got := strings.Join(states(t, m, migrations), ","); got != "pending,pending" {
+		t.Fatalf("states = %s", got)
+	}
+	if tableExists(t, conn, DefaultTable) {
+		t.Fatal("status created the tracking table")
+	}
+
+	if n, err := m.Up(ctx, migrations, 1); err != nil || n != 1 {
+		t.Fatalf("Up() with a limit = %d, %v", n, err)
+	}
+	if got := strings.Join(states(t, m, migrations), ","); got != "applied,pending" {
+		t.Fatalf("states = %s", got)
+	}
+	if n, err := m.Up(ctx, migrations, 0); err != nil || n != 1 {
+		t.Fatalf("Up() = %d, %v", n, err)
+	}
+	if !tableExists(t, conn, "users") || !tableExists(t, conn, "posts") {
+		t.Fatal("migrations were not applied")
+	}
+
+	// a migration changed after it was applied stops the run
+	changed := append([]*Migration{}, migrations...)
+	changedUsers := *migrations[0]
+	changedUsers.Checksum = strings.Repeat("0", len(changedUsers.Checksum))
+	changed[0] = &changedUsers
+	changed = append(changed, &Migration{Version: 3, Name: "comments", Up: "CREATE TABLE comments (id int)", Checksum: "3"})
+	if _, err := m.Up(ctx, changed, 0); err == nil || !strings.Contains(err.Error(), "was changed after it was applied") {
+		t.Fatalf("Up() with a changed migration = %v", err)
+	}
+	if tableExists(t, conn, "comments") {
+		t.Error("pending migration was applied after a changed one")
+	}
+	if got := strings.Join(states(t, m, changed), ","); got != "changed,applied,pending" {
+		t.Errorf("states = %s", got)
+	}
+
+	// applied migrations without files are missing
+	if got := strings.Join(states(t, m, migrations[:1]), ","); got != "applied,missing" {
+		t.Errorf("states = %s", got)
+	}
+
+	if n, err := m.Down(ctx, migrations, 0); err != nil || n != 2 {
+		t.Fatalf("Down() = %d, %v", n, err)
+	}
+	if tableExists(t, conn, "users") || tableExists(t, conn, "posts") {
+		t.Error("migrations were not reverted")
+	}
+	if got := strings.Join(states(t, m, migrations), ","); got != "pending,pending" {
+		t.Errorf("states = %s", got)
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	conn := testConn(t)
+	other := testConn(t)
+	ctx := context.Background()
+	migrations, err := Load(writeFiles(t, map[string]string{
+		"1_users.up.sql": "CREATE TABLE users (id int)",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another migrator holding the lock makes this one wait
+	if _, err := other.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, "SET lock_timeout = '200ms'"); err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{Conn: conn}
+	if _, err := m.Up(ctx, migrations, 0); err == nil || !strings.Contains(err.Error(), "unable to take migration lock") {
+		t.Fatalf("Up() while locked = %v", err)
+	}
+	if tableExists(t, conn, "users") {
+		t.Fatal("migration was applied without the lock")
+	}
+
+	if _, err := other.Exec(ctx, "SELECT pg_advisory_unlock($1)", lockKey); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Up(ctx, migrations, 0); err != nil || n != 1 {
+		t.Fatalf("Up() after the lock was released = %d, %v", n, err)
+	}
+
+	// the lock is released once the migrations ran
+	var locked bool
+	if err := other.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&locked); err != nil || !locked {
+		t.Fatalf("lock was not released: %v, %v", locked, err)
+	}
+	other.Exec(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+}
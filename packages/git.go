@@ -0,0 +1,248 @@
+package packages
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Fetcher downloads packages from git repositories into the cache, git
+// repositories are mirrored in the cache so they are only cloned once
+type Fetcher struct {
+	CacheDir string
+	Log      func(format string, args ...any)
+}
+
+// DefaultURL is the repository of a package path, e.g. https://github.com/org/lib.git
+func DefaultURL(path string) string {
+	return "https://" + path + ".git"
+}
+
+// Get resolves version to a commit, extracts it into the cache and returns
+// the package to lock, an empty version uses the default branch
+func (f *Fetcher) Get(ctx context.Context, path, version, url string) (Package, error) {
+	if err := ValidatePath(path); err != nil {
+		return Package{}, err
+	}
+	if url == "" {
+		url = DefaultURL(path)
+	}
+
+	// refs can move, the mirror is always updated before resolving them
+	mirror, err := f.mirror(ctx, url, true)
+	if err != nil {
+		return Package{}, err
+	}
+
+	rev := version
+	if rev == "" {
+		rev = "HEAD"
+	}
+	commit, err := git(ctx, mirror, "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return Package{}, fmt.Errorf("version %s of %s does not exist", rev, path)
+	}
+	if version == "" {
+		version = commit[:12]
+	}
+
+	pkg := Package{Path: path, Version: version, URL: url, Commit: commit}
+	if pkg.Hash, err = f.extract(ctx, mirror, pkg); err != nil {
+		return Package{}, err
+	}
+	return pkg, nil
+}
+
+// Install makes a locked package available in the cache, fetching the
+// repository only when the commit is missing from its mirror
+func (f *Fetcher) Install(ctx context.Context, pkg Package) error {
+	if err := pkg.validate(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(pkg.Dir(f.CacheDir)); err == nil {
+		return f.verify(pkg.Dir(f.CacheDir), pkg)
+	}
+
+	mirror, err := f.mirror(ctx, pkg.URL, false)
+	if err != nil {
+		return err
+	}
+	if _, err := git(ctx, mirror, "cat-file", "-e", "--end-of-options", pkg.Commit+"^{commit}"); err != nil {
+		if mirror, err = f.mirror(ctx, pkg.URL, true); err != nil {
+			return err
+		}
+	}
+
+	hash, err := f.extract(ctx, mirror, pkg)
+	if err != nil {
+		return err
+	}
+	if hash != pkg.Hash {
+		os.RemoveAll(pkg.Dir(f.CacheDir))
+		return fmt.Errorf("%s@%s: hash %s does not match the locked hash %s", pkg.Path, pkg.Version, hash, pkg.Hash)
+	}
+	return nil
+}
+
+// verify compares the files of a cached package with the locked hash
+func (f *Fetcher) verify(dir string, pkg Package) error {
+	hash, err := HashDir(dir)
+	if err != nil {
+		return err
+	}
+	if hash != pkg.Hash {
+		return fmt.Errorf("%s@%s: cached files in %s do not match the locked hash, remove the directory to download them again", pkg.Path, pkg.Version, dir)
+	}
+	return nil
+}
+
+// mirror returns the mirror of a repository in the cache, cloning it when
+// it does not exist yet and fetching new commits when update is set
+func (f *Fetcher) mirror(ctx context.Context, url string, update bool) (string, error) {
+	dir := filepath.Join(f.CacheDir, "git", fmt.Sprintf("%x", sha256.Sum256([]byte(url)))[:16])
+
+	if _, err := os.Stat(dir); err == nil {
+		if !update {
+			return dir, nil
+		}
+		f.logf("fetching %s", url)
+		if _, err := git(ctx, dir, "fetch", "--quiet", "--prune", "origin"); err != nil {
+			return "", fmt.Errorf("unable to fetch %s: %w", url, err)
+		}
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".clone-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	f.logf("cloning %s", url)
+	if _, err := git(ctx, "", "clone", "--quiet", "--mirror", "--", url, tmp); err != nil {
+		return "", fmt.Errorf("unable to clone %s: %w", url, err)
+	}
+	if err := rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// extract writes the files of a commit to the package directory and returns their hash
+func (f *Fetcher) extract(ctx context.Context, mirror string, pkg Package) (string, error) {
+	dir := pkg.Dir(f.CacheDir)
+	if _, err := os.Stat(dir); err == nil {
+		return HashDir(dir)
+	}
+
+	archive, err := run(ctx, mirror, "archive", "--format=tar", "--end-of-options", pkg.Commit)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s at %s: %w", pkg.Path, pkg.Commit, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".extract-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsLocal(header.Name) {
+			return "", fmt.Errorf("%s contains the invalid path %s", pkg.Path, header.Name)
+		}
+
+		// symlinks and other special files are not extracted
+		target := filepath.Join(tmp, filepath.FromSlash(header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return "", err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(file, reader)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	hash, err := HashDir(tmp)
+	if err != nil {
+		return "", err
+	}
+	if err := rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (f *Fetcher) logf(format string, args ...any) {
+	if f.Log != nil {
+		f.Log(format, args...)
+	}
+}
+
+// rename moves a directory into place, another process finishing first is not an error
+func rename(from, to string) error {
+	err := os.Rename(from, to)
+	if err != nil {
+		if _, statErr := os.Stat(to); statErr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	output, err := run(ctx, dir, args...)
+	return strings.TrimSpace(string(output)), err
+}
+
+// run runs a git command in dir and returns its output, values that are not
+// options are passed after -- or --end-of-options so they are never read as one
+func run(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+package packages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testRepository creates a bare repository with one commit of files and
+// returns its file:// url and the commit
+func testRepository(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	gitRun := func(dir string, args ...string) string {
+		t.Helper()
+		output, err := git(ctx, dir, append([]string{"-c", "user.name=pgstar", "-c", "user.email=pgstar@example.com"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return output
+	}
+
+	work := t.TempDir()
+	gitRun(work, "init", "--quiet")
+	writeFiles(t, work, files)
+	gitRun(work, "add", "--all")
+	gitRun(work, "commit", "--quiet", "--message", "initial")
+	commit := gitRun(work, "rev-parse", "HEAD")
+
+	bare := filepath.Join(t.TempDir(), "lib.git")
+	gitRun("", "clone", "--quiet", "--bare", work, bare)
+	return "file://" + filepath.ToSlash(bare), commit
+}
+
+func TestFetcher(t *testing.T) {
+	files := map[string]string{"lib.star": "x = 1", "sub/util.star": "y = 2"}
+	url, commit := testRepository(t, files)
+	ctx := context.Background()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	pkg, err := fetcher.Get(ctx, "example.com/org/lib", "", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Commit != commit || pkg.Version != commit[:12] || pkg.URL != url {
+		t.Errorf("Get() = %+v, want commit %s", pkg, commit)
+	}
+
+	// the hash covers the files of the commit
+	expected := t.TempDir()
+	writeFiles(t, expected, files)
+	if hash, err := HashDir(expected); err != nil || pkg.Hash != hash {
+		t.Errorf("hash = %s, want %s, %v", pkg.Hash, hash, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(pkg.Dir(fetcher.CacheDir), "sub", "util.star")); err != nil || string(data) != "y = 2" {
+		t.Errorf("extracted file = %q, %v", data, err)
+	}
+
+	// an empty cache installs the locked commit from the repository
+	other := &Fetcher{CacheDir: t.TempDir()}
+	if err := other.Install(ctx, pkg); err != nil {
+		t.Fatal(err)
+	}
+
+	// a cached package is only verified
+	if err := os.RemoveAll(filepath.Join(other.CacheDir, "git")); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Install(ctx, pkg); err != nil {
+		t.Fatalf("Install() of a cached package = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkg.Dir(other.CacheDir), "lib.star"), []byte("x = 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Install(ctx, pkg); err == nil || !strings.Contains(err.Error(), "do not match the locked hash") {
+		t.Errorf("Install() of a modified package = %v", err)
+	}
+
+	// a commit that does not exist is not installed
+	missing := pkg
+	missing.Commit = strings.Repeat("0", len(commit))
+	if err := other.Install(ctx, missing); err == nil {
+		t.Error("Install() of a missing commit did not fail")
+	}
+	if _, err := os.Stat(missing.Dir(other.CacheDir)); err == nil {
+		t.Error("missing commit was extracted")
+	}
+}
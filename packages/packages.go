@@ -0,0 +1,224 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LockfileName is the lockfile read from the directory of the configuration script
+const LockfileName = "pgstar.lock"
+
+// Package is a starlark library pinned to a commit of a git repository
+type Package struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	Commit  string `json:"commit"`
+	Hash    string `json:"hash"`
+}
+
+// Lockfile records the packages used by a configuration
+type Lockfile struct {
+	Packages []Package `json:"packages"`
+}
+
+// ReadLockfile reads the lockfile in dir, a missing lockfile has no packages
+func ReadLockfile(dir string) (*Lockfile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, LockfileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Lockfile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lockfile{}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", LockfileName, err)
+	}
+	for _, pkg := range lock.Packages {
+		if err := pkg.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", LockfileName, err)
+		}
+	}
+	return lock, nil
+}
+
+// Write writes the lockfile to dir with the packages sorted by path
+func (lock *Lockfile) Write(dir string) error {
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		return lock.Packages[i].Path < lock.Packages[j].Path
+	})
+	if lock.Packages == nil {
+		lock.Packages = []Package{}
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, LockfileName), append(data, '\n'), 0644)
+}
+
+// Set adds a package or replaces the locked version of it
+func (lock *Lockfile) Set(pkg Package) {
+	for i := range lock.Packages {
+		if lock.Packages[i].Path == pkg.Path {
+			lock.Packages[i] = pkg
+			return
+		}
+	}
+	lock.Packages = append(lock.Packages, pkg)
+}
+
+// Remove removes a package and reports whether it was locked
+func (lock *Lockfile) Remove(path string) bool {
+	for i := range lock.Packages {
+		if lock.Packages[i].Path == path {
+			lock.Packages = append(lock.Packages[:i], lock.Packages[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePath checks a package path such as github.com/org/lib, the first
+// element must look like a host so packages never shadow local scripts
+func ValidatePath(path string) error {
+	elements := strings.Split(path, "/")
+	if len(elements) < 2 || !strings.Contains(elements[0], ".") {
+		return fmt.Errorf("package path %s must start with a host, e.g. github.com/org/lib", path)
+	}
+	for _, element := range elements {
+		if element == "" || element == "." || element == ".." || strings.ContainsAny(element, "@\\:") {
+			return fmt.Errorf("package path %s is invalid", path)
+		}
+	}
+	return nil
+}
+
+// validate checks the values of a locked package that are passed to git and
+// used in cache paths, the commit must be a full object id
+func (pkg Package) validate() error {
+	if err := ValidatePath(pkg.Path); err != nil {
+		return err
+	}
+	if len(pkg.Commit) != 40 && len(pkg.Commit) != 64 || strings.Trim(pkg.Commit, "0123456789abcdef") != "" {
+		return fmt.Errorf("%s: commit %q is not a full commit id", pkg.Path, pkg.Commit)
+	}
+	if strings.HasPrefix(pkg.URL, "-") {
+		return fmt.Errorf("%s: url %q is invalid", pkg.Path, pkg.URL)
+	}
+	return nil
+}
+
+// DefaultCacheDir is $PGSTAR_CACHE_DIR or pgstar in the user cache directory
+func DefaultCacheDir() (string, error) {
+	if dir := os.Getenv("PGSTAR_CACHE_DIR"); dir != "" {
+		return filepath.Abs(dir)
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pgstar"), nil
+}
+
+// Dir returns the directory a package is extracted to in the cache
+func (pkg Package) Dir(cacheDir string) string {
+	return filepath.Join(cacheDir, "packages", filepath.FromSlash(pkg.Path)+"@"+pkg.Commit)
+}
+
+// Resolver maps load() paths of locked packages to files in the cache
+type Resolver struct {
+	CacheDir string
+	Packages []Package
+}
+
+// NewResolver returns a resolver for the lockfile in dir, nil when no
+// packages are locked. An empty cacheDir uses DefaultCacheDir, which is only
+// looked up when packages are locked.
+func NewResolver(dir, cacheDir string) (*Resolver, error) {
+	lock, err := ReadLockfile(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(lock.Packages) == 0 {
+		return nil, nil
+	}
+	if cacheDir == "" {
+		if cacheDir, err = DefaultCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	return &Resolver{CacheDir: cacheDir, Packages: lock.Packages}, nil
+}
+
+// Resolve returns the directory of the package containing modulePath and the
+// file within it, ok is false when modulePath is not part of a locked package
+func (resolver *Resolver) Resolve(modulePath string) (dir, file string, ok bool, err error) {
+	var match *Package
+	for i, pkg := range resolver.Packages {
+		if strings.HasPrefix(modulePath, pkg.Path+"/") && (match == nil || len(pkg.Path) > len(match.Path)) {
+			match = &resolver.Packages[i]
+		}
+	}
+	if match == nil {
+		return "", "", false, nil
+	}
+
+	file = strings.TrimPrefix(modulePath, match.Path+"/")
+	if !filepath.IsLocal(file) {
+		return "", "", true, fmt.Errorf("%s is outside of package %s", modulePath, match.Path)
+	}
+
+	dir = match.Dir(resolver.CacheDir)
+	if _, err := os.Stat(dir); err != nil {
+		return "", "", true, fmt.Errorf("package %s@%s is not in the cache, run pgstar get", match.Path, match.Version)
+	}
+	return dir, filepath.FromSlash(file), true, nil
+}
+
+// HashDir hashes the files of a directory, the hash covers the path and
+// content of every file so renaming or editing a file changes it
+func HashDir(dir string) (string, error) {
+	var lines []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sum := sha256.New()
+		if _, err := io.Copy(sum, file); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s\n", sum.Sum(nil), filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+	sum := sha256.New()
+	for _, line := range lines {
+		sum.Write([]byte(line))
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(sum.Sum(nil)), nil
+}
@@ -0,0 +1,243 @@
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"github.com/org/lib", true},
+		{"gitlab.example.com/group/sub/lib", true},
+		{"github.com/org", true},
+		{"lib", false},
+		{"org/lib", false},
+		{"localhost/lib", false},
+		{"github.com//lib", false},
+		{"github.com/org/", false},
+		{"github.com/./lib", false},
+		{"github.com/org/../lib", false},
+		{"github.com/org/lib@v1", false},
+		{"github.com/org\\lib", false},
+		{"github.com:22/org/lib", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := ValidatePath(tt.path)
+			if tt.valid && err != nil {
+				t.Errorf("ValidatePath(%q) = %v", tt.path, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("ValidatePath(%q) did not fail", tt.path)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cacheDir := t.TempDir()
+	lib := Package{Path: "github.com/org/lib", Version: "v1", Commit: "aaa"}
+	nested := Package{Path: "github.com/org/lib/nested", Version: "v2", Commit: "bbb"}
+	missing := Package{Path: "github.com/org/missing", Version: "v3", Commit: "ccc"}
+	for _, pkg := range []Package{lib, nested} {
+		if err := os.MkdirAll(pkg.Dir(cacheDir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolver := &Resolver{CacheDir: cacheDir, Packages: []Package{lib, nested, missing}}
+
+	tests := []struct {
+		name       string
+		modulePath string
+		dir        string
+		file       string
+		ok         bool
+		err        bool
+	}{
+		{"file in package", "github.com/org/lib/util.star", lib.Dir(cacheDir), "util.star", true, false},
+		{"file in subdirectory", "github.com/org/lib/sub/util.star", lib.Dir(cacheDir), filepath.Join("sub", "util.star"), true, false},
+		{"longest prefix wins", "github.com/org/lib/nested/util.star", nested.Dir(cacheDir), "util.star", true, false},
+		{"prefix must end at a path element", "github.com/org/library/util.star", "", "", false, false},
+		{"package path itself", "github.com/org/lib", "", "", false, false},
+		{"local script", "util.star", "", "", false, false},
+		{"outside of package", "github.com/org/lib/../other/util.star", "", "", true, true},
+		{"not in the cache", "github.com/org/missing/util.star", "", "", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, file, ok, err := resolver.Resolve(tt.modulePath)
+			if (err != nil) != tt.err {
+				t.Fatalf("Resolve(%q) error = %v", tt.modulePath, err)
+			}
+			if dir != tt.dir || file != tt.file || ok != tt.ok {
+				t.Errorf("Resolve(%q) = %q, %q, %v, want %q, %q, %v", tt.modulePath, dir, file, ok, tt.dir, tt.file, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHashDir(t *testing.T) {
+	write := func(dir string, files map[string]string) {
+		for name, contents := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	hash := func(files map[string]string) string {
+		dir := t.TempDir()
+		write(dir, files)
+		sum, err := HashDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	base := map[string]string{"lib.star": "x = 1", "sub/util.star": "y = 2"}
+	tests := []struct {
+		name  string
+		files map[string]string
+		same  bool
+	}{
+		{"same files", map[string]string{"sub/util.star": "y = 2", "lib.star": "x = 1"}, true},
+		{"edited file", map[string]string{"lib.star": "x = 2", "sub/util.star": "y = 2"}, false},
+		{"renamed file", map[string]string{"main.star": "x = 1", "sub/util.star": "y = 2"}, false},
+		{"moved file", map[string]string{"lib.star": "x = 1", "util.star": "y = 2"}, false},
+		{"added file", map[string]string{"lib.star": "x = 1", "sub/util.star": "y = 2", "new.star": ""}, false},
+		{"removed file", map[string]string{"lib.star": "x = 1"}, false},
+	}
+
+	want := hash(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hash(tt.files); (got == want) != tt.same {
+				t.Errorf("HashDir() = %s, base = %s, same = %v", got, want, tt.same)
+			}
+		})
+	}
+
+	// empty directories are not part of the hash
+	dir := t.TempDir()
+	write(dir, base)
+	if err := os.MkdirAll(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := HashDir(dir); err != nil || got != want {
+		t.Errorf("HashDir() with an empty directory = %s, %v, want %s", got, err, want)
+	}
+}
+
+func TestLockfile(t *testing.T) {
+	a := Package{Path: "github.com/org/a", Version: "v1"}
+	b := Package{Path: "github.com/org/b", Version: "v1"}
+	b2 := Package{Path: "github.com/org/b", Version: "v2"}
+
+	tests := []struct {
+		name    string
+		initial []Package
+		set     []Package
+		remove  string
+		removed bool
+		want    []Package
+	}{
+		{"add", nil, []Package{a}, "", false, []Package{a}},
+		{"add second", []Package{a}, []Package{b}, "", false, []Package{a, b}},
+		{"replace version", []Package{a, b}, []Package{b2}, "", false, []Package{a, b2}},
+		{"remove", []Package{a, b}, nil, "github.com/org/a", true, []Package{b}},
+		{"remove last", []Package{a}, nil, "github.com/org/a", true, []Package{}},
+		{"remove unknown", []Package{a}, nil, "github.com/org/c", false, []Package{a}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock := &Lockfile{Packages: append([]Package{}, tt.initial...)}
+			for _, pkg := range tt.set {
+				lock.Set(pkg)
+			}
+			if tt.remove != "" {
+				if removed := lock.Remove(tt.remove); removed != tt.removed {
+					t.Errorf("Remove(%q) = %v, want %v", tt.remove, removed, tt.removed)
+				}
+			}
+			if !reflect.DeepEqual(lock.Packages, tt.want) {
+				t.Errorf("packages = %+v, want %+v", lock.Packages, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockfileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	// a missing lockfile has no packages and no resolver
+	lock, err := ReadLockfile(dir)
+	if err != nil || len(lock.Packages) != 0 {
+		t.Fatalf("ReadLockfile() = %+v, %v", lock, err)
+	}
+	if resolver, err := NewResolver(dir, ""); resolver != nil || err != nil {
+		t.Fatalf("NewResolver() = %+v, %v, want nil", resolver, err)
+	}
+
+	lock.Set(Package{Path: "github.com/org/b", Version: "v1", Commit: strings.Repeat("b", 40)})
+	lock.Set(Package{Path: "github.com/org/a", Version: "v1", Commit: strings.Repeat("a", 40)})
+	if err := lock.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadLockfile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Packages) != 2 || read.Packages[0].Path != "github.com/org/a" {
+		t.Errorf("packages are not sorted by path: %+v", read.Packages)
+	}
+
+	resolver, err := NewResolver(dir, "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver == nil || resolver.CacheDir != "/cache" || len(resolver.Packages) != 2 {
+		t.Errorf("NewResolver() = %+v", resolver)
+	}
+}
+
+func TestReadLockfileRejectsInvalidPackages(t *testing.T) {
+	commit := strings.Repeat("0123456789", 4)
+	tests := []struct {
+		name string
+		pkg  Package
+		err  string
+	}{
+		{"invalid path", Package{Path: "lib", URL: "https://example.com/lib.git", Commit: commit}, "must start with a host"},
+		{"short commit", Package{Path: "github.com/org/lib", URL: "https://example.com/lib.git", Commit: commit[:12]}, "not a full commit id"},
+		{"commit that escapes the cache", Package{Path: "github.com/org/lib", URL: "https://example.com/lib.git", Commit: "../../../" + commit[:31]}, "not a full commit id"},
+		{"commit that is an option", Package{Path: "github.com/org/lib", URL: "https://example.com/lib.git", Commit: "--output=/tmp/pwned" + commit[:21]}, "not a full commit id"},
+		{"url that is an option", Package{Path: "github.com/org/lib", URL: "--upload-pack=touch /tmp/pwned;false", Commit: commit}, "url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			lock := &Lockfile{Packages: []Package{tt.pkg}}
+			if err := lock.Write(dir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadLockfile(dir); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ReadLockfile() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
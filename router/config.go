@@ -5,23 +5,36 @@ import (
 	"net/http/pprof"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+	"github.com/protosam/pgstar/packages"
 	"go.starlark.net/starlark"
 )
 
 type route struct {
-	Methods []string
-	Path    string
-	Script  string
+	Methods    []string
+	Path       string
+	Script     string
+	Options    []WithOption
+	Retry      RetryPolicy
+	Meta       routeMeta
+	Validation routeValidation
 }
 
 type Config struct {
 	rootdir    string
 	routes     []route
+	listeners  []listener
+	databases  map[string]namedPool
+	queries    modpostgres.Queries
 	globals    map[string]starlark.Value
 	pprofRoute string
+	openapi    openAPIInfo
 	options    []WithOption
 }
 
@@ -30,6 +43,17 @@ type WithOption interface {
 }
 
 func ConfigureAndBuildRouter(starscript string, opts ...WithOption) (*mux.Router, error) {
+	cfg, err := Configure(starscript, opts...)
+	if err != nil {
+		return nil, err
+	}
+	cfg.UsePools()
+
+	return cfg.BuildRouter(), nil
+}
+
+// Configure runs the configuration script and returns the resulting Config
+func Configure(starscript string, opts ...WithOption) (*Config, error) {
 	// script might be temporarily unavailable due to how some editors handle writes
 	if err := waitForFile(starscript, configFileTimeout); err != nil {
 		return nil, err
@@ -43,25 +67,65 @@ func ConfigureAndBuildRouter(starscript string, opts ...WithOption) (*mux.Router
 	thread.Predeclare("getEnv", starlark.NewBuiltin("getEnv", cfg.GetEnv))
 	thread.Predeclare("setGlobal", starlark.NewBuiltin("setGlobal", cfg.SetGlobal))
 	thread.Predeclare("addRoute", starlark.NewBuiltin("addRoute", cfg.AddRoute))
+	thread.Predeclare("addListener", starlark.NewBuiltin("addListener", cfg.AddListener))
+	thread.Predeclare("addDatabase", starlark.NewBuiltin("addDatabase", cfg.AddDatabase))
+	thread.Predeclare("addResource", starlark.NewBuiltin("addResource", cfg.AddResource))
+	thread.Predeclare("addQuery", starlark.NewBuiltin("addQuery", cfg.AddQuery))
+	thread.Predeclare("addQueriesFromDir", starlark.NewBuiltin("addQueriesFromDir", cfg.AddQueriesFromDir))
 	thread.Predeclare("enableProfilerRoute", starlark.NewBuiltin("enableProfilerRoute", cfg.EnableProfilerRoute))
+	thread.Predeclare("enableOpenAPIRoute", starlark.NewBuiltin("enableOpenAPIRoute", cfg.EnableOpenAPIRoute))
 	thread.SetModuleLoader(executor.NewModuleLoader(thread, thread.GetRootdir(), thread.GetStarfile()))
 
-	for i := range opts {
-		opts[i].Apply(thread)
+	// packages locked with pgstar get are read from the cache, never the network
+	resolver, err := packages.NewResolver(cfg.rootdir, "")
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		cfg.options = append(append([]WithOption{}, cfg.options...), &WithPackages{Resolver: resolver})
 	}
 
-	_, err := thread.Exec()
+	for i := range cfg.options {
+		cfg.options[i].Apply(thread)
+	}
+
+	_, err = thread.Exec()
 	if err != nil {
+		cfg.closeNewPools()
 		return nil, fmt.Errorf("configuration failed to run: %w", err)
 	}
 
-	return cfg.BuildRouter(), nil
+	// named databases are available to every script
+	if len(cfg.databases) > 0 {
+		pools := make(map[string]*pgxpool.Pool, len(cfg.databases))
+		for name, database := range cfg.databases {
+			pools[name] = database.pool
+		}
+		cfg.options = append(append([]WithOption{}, cfg.options...), &WithDatabases{Pools: pools})
+	}
+
+	// named queries are available to every script
+	if len(cfg.queries) > 0 {
+		cfg.options = append(append([]WithOption{}, cfg.options...), &WithQueries{Queries: cfg.queries})
+	}
+
+	return cfg, nil
 }
 
 func (cfg *Config) BuildRouter() *mux.Router {
 	router := mux.NewRouter()
 	for _, route := range cfg.routes {
-		router.HandleFunc(route.Path, WithStarlarkHandler(cfg.rootdir, route.Script, cfg.globals, cfg.options...)).Methods(route.Methods...)
+		opts := append(append([]WithOption{}, cfg.options...), route.Options...)
+		handler := WithStarlarkHandler(cfg.rootdir, route.Script, cfg.globals, opts...)
+		if route.Retry.Retries > 0 {
+			handler = WithRetryingStarlarkHandler(route.Retry, cfg.rootdir, route.Script, cfg.globals, opts...)
+		}
+		router.HandleFunc(route.Path, withValidation(route.Validation, handler)).Methods(route.Methods...)
+	}
+
+	// serve the OpenAPI document generated from the routes
+	if cfg.openapi.Route != "" {
+		router.HandleFunc(cfg.openapi.Route, openAPIHandler(cfg.OpenAPI())).Methods("GET")
 	}
 
 	// enable pprof for Go debugging
@@ -127,10 +191,69 @@ func (cfg *Config) AddRoute(thread *starlark.Thread, fn *starlark.Builtin, args
 	sval_methods := starlark.NewList(nil)
 	var path string
 	var script string
-	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "methods", &sval_methods, "path", &path, "script", &script); err != nil {
+	var isolation, access string
+	var deferrable bool
+	var retries int
+	var retryBackoff float64
+	var queryTimeout float64
+	var role string
+	settings := starlark.NewDict(0)
+	var summary, description string
+	var tags *starlark.List
+	var bodySchema, querySchema, varsSchema, responseSchema starlark.Value
+	pathParams := starlark.NewDict(0)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "methods", &sval_methods, "path", &path, "script", &script,
+		"isolation?", &isolation, "access?", &access, "deferrable?", &deferrable,
+		"retries?", &retries, "retryBackoff?", &retryBackoff, "queryTimeout?", &queryTimeout,
+		"role?", &role, "settings?", &settings,
+		"summary?", &summary, "description?", &description, "tags?", &tags,
+		"bodySchema?", &bodySchema, "querySchema?", &querySchema, "varsSchema?", &varsSchema,
+		"responseSchema?", &responseSchema, "pathParams?", &pathParams); err != nil {
 		return starlark.None, err
 	}
 
+	// schemas are compiled once here instead of for every request
+	var validation routeValidation
+	var err error
+	if validation.Body, err = cfg.compileSchema(path+" bodySchema", bodySchema); err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+	if validation.Query, err = cfg.compileSchema(path+" querySchema", querySchema); err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+	if validation.Vars, err = cfg.compileSchema(path+" varsSchema", varsSchema); err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	meta, err := parseRouteMeta(summary, description, tags, validation, responseSchema, pathParams)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	if retries < 0 || retryBackoff < 0 || queryTimeout < 0 {
+		return starlark.None, fmt.Errorf("%s: retries, retryBackoff and queryTimeout must not be negative", fn.Name())
+	}
+
+	txOptions, err := parseTxOptions(isolation, access, deferrable)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	options := []WithOption{txOptions}
+	if txOptions.TxOptions.AccessMode == pgx.ReadOnly {
+		options = append(options, &WithReplica{})
+	}
+	if queryTimeout > 0 {
+		options = append(options, &WithQueryTimeout{Timeout: time.Duration(queryTimeout * float64(time.Second))})
+	}
+	if role != "" || settings.Len() > 0 {
+		localSettings, err := parseLocalSettings(role, settings)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		options = append(options, localSettings)
+	}
+
 	var methods []string
 	for i := 0; i < sval_methods.Len(); i++ {
 		if method, ok := starlark.AsString(sval_methods.Index(i)); ok {
@@ -144,6 +267,13 @@ func (cfg *Config) AddRoute(thread *starlark.Thread, fn *starlark.Builtin, args
 		Methods: methods,
 		Path:    path,
 		Script:  script,
+		Options: options,
+		Retry: RetryPolicy{
+			Retries: retries,
+			Backoff: time.Duration(retryBackoff * float64(time.Second)),
+		},
+		Meta:       meta,
+		Validation: validation,
 	})
 
 	return starlark.None, nil
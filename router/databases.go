@@ -0,0 +1,152 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+	"go.starlark.net/starlark"
+)
+
+type namedPool struct {
+	dsn  string
+	pool *pgxpool.Pool
+}
+
+// pools are kept between configuration reloads so connections are reused,
+// namedPools holds the pools of the configuration that is in use
+var namedPoolsMu sync.Mutex
+var namedPools = map[string]namedPool{}
+
+// newNamedPool creates the pools of named databases, see SetNamedPoolOpener
+var newNamedPool = func(dsn string) (*pgxpool.Pool, error) {
+	return pgxpool.New(context.Background(), dsn)
+}
+
+// closeNamedPool closes pools that are no longer used, in-flight requests may
+// still use them and Close waits for them
+var closeNamedPool = func(pool *pgxpool.Pool) {
+	go pool.Close()
+}
+
+// SetNamedPoolOpener sets how pools for addDatabase() are created, so they
+// are tuned the same way as the primary pool
+func SetNamedPoolOpener(open func(dsn string) (*pgxpool.Pool, error)) {
+	namedPoolsMu.Lock()
+	defer namedPoolsMu.Unlock()
+	newNamedPool = open
+}
+
+// openNamedPool returns the pool for a named database, a new pool is only
+// created when the name is new or its connection string changed. Pools in use
+// are not replaced until the configuration is activated, see UsePools
+func (cfg *Config) openNamedPool(name, dsn string) (*pgxpool.Pool, error) {
+	namedPoolsMu.Lock()
+	defer namedPoolsMu.Unlock()
+
+	if existing, ok := cfg.databases[name]; ok {
+		if existing.dsn == dsn {
+			return existing.pool, nil
+		}
+		// the same name was added twice in this configuration
+		if active, ok := namedPools[name]; !ok || active.pool != existing.pool {
+			closeNamedPool(existing.pool)
+		}
+	}
+
+	pool := namedPools[name].pool
+	if namedPools[name].dsn != dsn {
+		var err error
+		if pool, err = newNamedPool(dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.databases == nil {
+		cfg.databases = make(map[string]namedPool)
+	}
+	cfg.databases[name] = namedPool{dsn: dsn, pool: pool}
+
+	return pool, nil
+}
+
+// UsePools makes the pools of cfg the ones in use, once its router has
+// replaced the previous one. Pools that were replaced or whose database was
+// removed are closed.
+func (cfg *Config) UsePools() {
+	namedPoolsMu.Lock()
+	defer namedPoolsMu.Unlock()
+
+	for name, active := range namedPools {
+		if current, ok := cfg.databases[name]; !ok || current.pool != active.pool {
+			closeNamedPool(active.pool)
+		}
+	}
+
+	namedPools = make(map[string]namedPool, len(cfg.databases))
+	for name, current := range cfg.databases {
+		namedPools[name] = current
+	}
+}
+
+// closeNewPools closes the pools opened for a configuration that failed, the
+// pools in use are kept
+func (cfg *Config) closeNewPools() {
+	namedPoolsMu.Lock()
+	defer namedPoolsMu.Unlock()
+
+	for name, current := range cfg.databases {
+		if active, ok := namedPools[name]; !ok || active.pool != current.pool {
+			closeNamedPool(current.pool)
+		}
+	}
+	cfg.databases = nil
+}
+
+// WithDatabases makes named databases available to scripts through
+// load("pgstar/postgres/<name>") and db.use(name)
+type WithDatabases struct {
+	Pools map[string]*pgxpool.Pool
+}
+
+func (opt *WithDatabases) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying databases")
+	}
+	for name, pool := range opt.Pools {
+		if err := loader.SetState(modpostgres.StateNameForDatabase(name), pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) AddDatabase(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, dsnEnvVar string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "dsnEnvVar", &dsnEnvVar); err != nil {
+		return starlark.None, err
+	}
+
+	if name == "" {
+		return starlark.None, fmt.Errorf("%s: name must not be empty", fn.Name())
+	}
+
+	// ensure environment variables are prefixed
+	dsnEnvVar = "PGSTAR_ENV_" + dsnEnvVar
+
+	dsn := os.Getenv(dsnEnvVar)
+	if dsn == "" {
+		return starlark.None, fmt.Errorf("%s: environment variable %s is not set", fn.Name(), dsnEnvVar)
+	}
+
+	if _, err := cfg.openNamedPool(name, dsn); err != nil {
+		return starlark.None, fmt.Errorf("%s: unable to create connection pool for %s: %w", fn.Name(), name, err)
+	}
+
+	return starlark.None, nil
+}
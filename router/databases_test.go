@@ -0,0 +1,103 @@
+package router
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestNamedPoolsAcrossReloads(t *testing.T) {
+	dir := t.TempDir()
+	starfile := filepath.Join(dir, "config.star")
+	t.Setenv("PGSTAR_ENV_FIRST", "postgres://localhost:1/first")
+	t.Setenv("PGSTAR_ENV_SECOND", "postgres://localhost:1/second")
+	t.Setenv("PGSTAR_ENV_CHANGED", "postgres://localhost:1/changed")
+
+	// pools are never connected, closing them is only recorded
+	opened := map[*pgxpool.Pool]string{}
+	var closed []string
+	previousOpen, previousClose, previousPools := newNamedPool, closeNamedPool, namedPools
+	newNamedPool = func(dsn string) (*pgxpool.Pool, error) {
+		pool, err := pgxpool.New(context.Background(), dsn)
+		opened[pool] = filepath.Base(dsn)
+		return pool, err
+	}
+	closeNamedPool = func(pool *pgxpool.Pool) {
+		closed = append(closed, opened[pool])
+		pool.Close()
+	}
+	namedPools = map[string]namedPool{}
+	t.Cleanup(func() {
+		newNamedPool, closeNamedPool, namedPools = previousOpen, previousClose, previousPools
+	})
+
+	configure := func(src string) (*Config, error) {
+		writeScript(t, dir, "config.star", src)
+		return Configure(starfile)
+	}
+	expectClosed := func(step string, want ...string) {
+		t.Helper()
+		if len(closed) != len(want) {
+			t.Fatalf("%s: closed = %q, want %q", step, closed, want)
+		}
+		for i := range want {
+			if closed[i] != want[i] {
+				t.Fatalf("%s: closed = %q, want %q", step, closed, want)
+			}
+		}
+		closed = nil
+	}
+
+	cfg, err := configure(`
+addDatabase("a", "FIRST")
+addDatabase("b", "SECOND")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.UsePools()
+	active := namedPools["a"].pool
+	expectClosed("initial")
+
+	// a failed reload closes the pools it opened and keeps the ones in use
+	if _, err := configure(`
+addDatabase("a", "CHANGED")
+fail("broken")
+`); err == nil {
+		t.Fatal("broken configuration did not fail")
+	}
+	expectClosed("failed reload", "changed")
+	if namedPools["a"].pool != active || len(namedPools) != 2 {
+		t.Fatalf("pools in use changed after a failed reload: %+v", namedPools)
+	}
+
+	// unchanged databases keep their pool
+	cfg, err = configure(`
+addDatabase("a", "FIRST")
+addDatabase("b", "CHANGED")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.databases["a"].pool != active {
+		t.Error("pool of an unchanged database was replaced")
+	}
+
+	// replaced pools are only closed once the new configuration is in use
+	expectClosed("reload")
+	cfg.UsePools()
+	expectClosed("reload in use", "second")
+
+	// removed databases are closed
+	cfg, err = configure(`addDatabase("b", "CHANGED")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.UsePools()
+	expectClosed("removed", "first")
+	if len(namedPools) != 1 {
+		t.Errorf("pools in use = %+v", namedPools)
+	}
+}
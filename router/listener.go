@@ -0,0 +1,109 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modlistener"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+	"go.starlark.net/starlark"
+)
+
+type listener struct {
+	Channel string
+	Script  string
+}
+
+func (cfg *Config) AddListener(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var channel, script string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "channel", &channel, "script", &script); err != nil {
+		return starlark.None, err
+	}
+
+	cfg.listeners = append(cfg.listeners, listener{
+		Channel: channel,
+		Script:  script,
+	})
+
+	return starlark.None, nil
+}
+
+// StartListeners runs the configured listeners until ctx is cancelled, each
+// listener holds a dedicated connection from the pool
+func (cfg *Config) StartListeners(ctx context.Context) {
+	for i := range cfg.listeners {
+		go cfg.listen(ctx, cfg.listeners[i])
+	}
+}
+
+func (cfg *Config) listen(ctx context.Context, l listener) {
+	for {
+		if err := cfg.waitForNotifications(ctx, l); err != nil && ctx.Err() == nil {
+			log.Printf("listener %s: %s, reconnecting in %s", l.Channel, err, listenerReconnectDelay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenerReconnectDelay):
+		}
+	}
+}
+
+func (cfg *Config) waitForNotifications(ctx context.Context, l listener) error {
+	if dbpool == nil {
+		return errors.New("database pool is not set")
+	}
+
+	poolConn, err := dbpool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the connection is taken out of the pool so LISTEN does not leak to other requests
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{l.Channel}.Sanitize()); err != nil {
+		return err
+	}
+	log.Printf("listener %s: listening for notifications", l.Channel)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.runListenerScript(l, notification)
+	}
+}
+
+// runListenerScript executes a listener script in its own transaction
+func (cfg *Config) runListenerScript(l listener, notification *pgconn.Notification) {
+	thread := executor.NewManagedThread(cfg.rootdir, l.Script)
+	moduleloader := executor.NewModuleLoader(thread, thread.GetRootdir(), thread.GetStarfile())
+	moduleloader.SetState(modpostgres.StateNameDBPool, dbpool)
+	moduleloader.SetState(modlistener.StateNameNotification, notification)
+	thread.SetModuleLoader(moduleloader)
+
+	for name, value := range cfg.globals {
+		thread.Predeclare(name, value)
+	}
+
+	for i := range cfg.options {
+		cfg.options[i].Apply(thread)
+	}
+
+	err := errScriptPanicked
+	defer func() { moduleloader.Destroy(err) }()
+
+	_, err = thread.Exec()
+	if err != nil {
+		log.Printf("%s: error: %s", thread.Name, err)
+	}
+}
@@ -0,0 +1,333 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/protosam/pgstar/executor/modules/starutils"
+	"go.starlark.net/starlark"
+)
+
+// routeMeta documents a route in the generated OpenAPI document
+type routeMeta struct {
+	Summary        string
+	Description    string
+	Tags           []string
+	BodySchema     any
+	QuerySchema    any
+	VarsSchema     any
+	ResponseSchema any
+	PathParams     map[string]any
+}
+
+// openAPIInfo is set with enableOpenAPIRoute()
+type openAPIInfo struct {
+	Route   string
+	Title   string
+	Version string
+}
+
+// pathVariable matches gorilla path variables, {name} or {name:pattern}
+var pathVariable = regexp.MustCompile(`\{([^{}:]+)(?::((?:[^{}]|\{[^{}]*\})*))?\}`)
+
+// toJSONValue converts a starlark value into a value that encodes as JSON
+func toJSONValue(value starlark.Value) (any, error) {
+	encoded, err := starutils.StarlarkJsonEncoder(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// parseRouteMeta reads the documentation arguments of addRoute()
+func parseRouteMeta(summary, description string, sltags *starlark.List, validation routeValidation, responseSchema starlark.Value, pathParams *starlark.Dict) (routeMeta, error) {
+	meta := routeMeta{Summary: summary, Description: description}
+	if validation.Body != nil {
+		meta.BodySchema = validation.Body.Document
+	}
+	if validation.Query != nil {
+		meta.QuerySchema = validation.Query.Document
+	}
+	if validation.Vars != nil {
+		meta.VarsSchema = validation.Vars.Document
+	}
+
+	if sltags != nil {
+		for i := 0; i < sltags.Len(); i++ {
+			tag, ok := starlark.AsString(sltags.Index(i))
+			if !ok {
+				return meta, fmt.Errorf("tags must be a list of strings")
+			}
+			meta.Tags = append(meta.Tags, tag)
+		}
+	}
+
+	var err error
+	if responseSchema != nil && responseSchema != starlark.None {
+		if meta.ResponseSchema, err = toJSONValue(responseSchema); err != nil {
+			return meta, fmt.Errorf("responseSchema: %w", err)
+		}
+	}
+
+	// path parameters are a type name such as "integer" or a full schema
+	if pathParams != nil && pathParams.Len() > 0 {
+		meta.PathParams = map[string]any{}
+		for _, item := range pathParams.Items() {
+			name, ok := starlark.AsString(item[0])
+			if !ok {
+				return meta, fmt.Errorf("pathParams keys must be strings")
+			}
+			if typ, ok := starlark.AsString(item[1]); ok {
+				meta.PathParams[name] = map[string]any{"type": typ}
+				continue
+			}
+			if meta.PathParams[name], err = toJSONValue(item[1]); err != nil {
+				return meta, fmt.Errorf("pathParams %s: %w", name, err)
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// schemaProperties returns the top level properties of an object schema and the required names
+func schemaProperties(schema any) (map[string]any, []any) {
+	document, ok := schema.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	properties, _ := document["properties"].(map[string]any)
+	required, _ := document["required"].([]any)
+	return properties, required
+}
+
+// openAPIPath converts a gorilla path template into an OpenAPI path and its parameters
+func openAPIPath(path string, meta routeMeta) (string, []any) {
+	var parameters []any
+	varsProperties, _ := schemaProperties(meta.VarsSchema)
+	converted := pathVariable.ReplaceAllStringFunc(path, func(variable string) string {
+		match := pathVariable.FindStringSubmatch(variable)
+		name, pattern := match[1], match[2]
+
+		schema, ok := meta.PathParams[name]
+		if !ok {
+			schema, ok = varsProperties[name]
+		}
+		if !ok {
+			s := map[string]any{"type": "string"}
+			if pattern != "" {
+				s["pattern"] = "^" + pattern + "$"
+			}
+			schema = s
+		}
+		parameters = append(parameters, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+		return "{" + name + "}"
+	})
+
+	// query parameters are the properties of the query schema
+	queryProperties, required := schemaProperties(meta.QuerySchema)
+	names := make([]string, 0, len(queryProperties))
+	for name := range queryProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parameters = append(parameters, map[string]any{
+			"name":     name,
+			"in":       "query",
+			"required": slices.Contains(required, any(name)),
+			"schema":   queryProperties[name],
+		})
+	}
+
+	return converted, parameters
+}
+
+// OpenAPI returns an OpenAPI 3.1 document describing the configured routes
+func (cfg *Config) OpenAPI() map[string]any {
+	title, version := "PGStar", "0.0.0"
+	if cfg.openapi.Title != "" {
+		title = cfg.openapi.Title
+	}
+	if cfg.openapi.Version != "" {
+		version = cfg.openapi.Version
+	}
+
+	paths := map[string]any{}
+	for _, route := range cfg.routes {
+		path, parameters := openAPIPath(route.Path, route.Meta)
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+
+		for _, method := range route.Methods {
+			operation := map[string]any{
+				"operationId": operationID(method, path),
+			}
+			if route.Meta.Summary != "" {
+				operation["summary"] = route.Meta.Summary
+			}
+			if route.Meta.Description != "" {
+				operation["description"] = route.Meta.Description
+			}
+			if len(route.Meta.Tags) > 0 {
+				operation["tags"] = route.Meta.Tags
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			if route.Meta.BodySchema != nil && method != "GET" && method != "HEAD" {
+				operation["requestBody"] = map[string]any{
+					"required": true,
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": route.Meta.BodySchema},
+					},
+				}
+			}
+
+			response := map[string]any{"description": "Response written by " + route.Script}
+			if route.Meta.ResponseSchema != nil {
+				response["content"] = map[string]any{
+					"application/json": map[string]any{"schema": route.Meta.ResponseSchema},
+				}
+			}
+			operation["responses"] = map[string]any{"default": response}
+
+			item[strings.ToLower(method)] = operation
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+	}
+}
+
+// operationID builds a stable identifier such as get_users_id from a method and path
+func operationID(method, path string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "_")
+}
+
+// openAPIHandler serves the document generated when the router was built
+func openAPIHandler(document map[string]any) func(http.ResponseWriter, *http.Request) {
+	data, err := json.Marshal(document)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+// columnSchema maps a postgres type name from format_type() to a JSON schema
+func columnSchema(typ string) map[string]any {
+	if strings.HasSuffix(typ, "[]") {
+		return map[string]any{"type": "array", "items": columnSchema(strings.TrimSuffix(typ, "[]"))}
+	}
+	base := typ
+	if i := strings.Index(base, "("); i >= 0 {
+		base = base[:i]
+	}
+	switch base {
+	case "smallint", "integer", "bigint":
+		return map[string]any{"type": "integer"}
+	case "real", "double precision":
+		return map[string]any{"type": "number"}
+	case "numeric":
+		// numerics are returned as strings to keep their precision
+		return map[string]any{"type": "string"}
+	case "boolean":
+		return map[string]any{"type": "boolean"}
+	case "json", "jsonb":
+		return map[string]any{}
+	case "uuid":
+		return map[string]any{"type": "string", "format": "uuid"}
+	case "date":
+		return map[string]any{"type": "string", "format": "date"}
+	case "timestamp with time zone", "timestamp without time zone":
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	return map[string]any{"type": "string"}
+}
+
+// resourceSummaries are the summaries of the resource actions, %s is the table
+var resourceSummaries = map[string]string{
+	"list":   "List %s",
+	"get":    "Get a row of %s",
+	"create": "Create a row in %s",
+	"update": "Update a row of %s",
+	"delete": "Delete a row of %s",
+}
+
+// resourceMeta documents a route registered by addResource()
+func resourceMeta(table, action, key string, columns []resourceColumn, exposed []string, writable []string) routeMeta {
+	byName := map[string]resourceColumn{}
+	for _, column := range columns {
+		byName[column.Name] = column
+	}
+
+	properties := map[string]any{}
+	for _, name := range exposed {
+		properties[name] = columnSchema(byName[name].Type)
+	}
+	row := map[string]any{"type": "object", "properties": properties}
+
+	meta := routeMeta{Tags: []string{table}, Summary: fmt.Sprintf(resourceSummaries[action], table)}
+	if action == "get" || action == "update" || action == "delete" {
+		meta.PathParams = map[string]any{"key": columnSchema(byName[key].Type)}
+	}
+
+	switch action {
+	case "list":
+		meta.ResponseSchema = map[string]any{"type": "array", "items": row}
+	case "get":
+		meta.ResponseSchema = row
+	case "create", "update":
+		writableProperties := map[string]any{}
+		for _, name := range writable {
+			writableProperties[name] = properties[name]
+		}
+		meta.BodySchema = map[string]any{"type": "object", "properties": writableProperties, "additionalProperties": false}
+		meta.ResponseSchema = row
+	case "delete":
+		meta.ResponseSchema = row
+	}
+
+	return meta
+}
+
+func (cfg *Config) EnableOpenAPIRoute(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "route", &cfg.openapi.Route,
+		"title?", &cfg.openapi.Title, "version?", &cfg.openapi.Version); err != nil {
+		return starlark.None, err
+	}
+	return starlark.None, nil
+}
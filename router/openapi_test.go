@@ -0,0 +1,29 @@
+package router
+
+import "testing"
+
+func TestResourceMetaSummary(t *testing.T) {
+	columns := []resourceColumn{{Name: "id", Type: "bigint", Primary: true}, {Name: "email", Type: "text"}}
+	exposed := []string{"id", "email"}
+	writable := []string{"email"}
+
+	tests := []struct {
+		action string
+		want   string
+	}{
+		{"list", "List users"},
+		{"get", "Get a row of users"},
+		{"create", "Create a row in users"},
+		{"update", "Update a row of users"},
+		{"delete", "Delete a row of users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			meta := resourceMeta("users", tt.action, "id", columns, exposed, writable)
+			if meta.Summary != tt.want {
+				t.Errorf("summary = %q, want %q", meta.Summary, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+	"go.starlark.net/starlark"
+)
+
+// WithQueries makes the query catalog available to scripts through db.named()
+type WithQueries struct {
+	Queries modpostgres.Queries
+}
+
+func (opt *WithQueries) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying queries")
+	}
+	queries := opt.Queries
+	return loader.SetState(modpostgres.StateNameQueries, &queries)
+}
+
+// Queries returns the query catalog with the metadata reported when each
+// statement was prepared
+func (cfg *Config) Queries() modpostgres.Queries {
+	return cfg.queries
+}
+
+// addQuery prepares a statement and adds it to the catalog
+func (cfg *Config) addQuery(name, sql string) error {
+	if name == "" {
+		return fmt.Errorf("query name must not be empty")
+	}
+	if _, ok := cfg.queries[name]; ok {
+		return fmt.Errorf("query %q is already defined", name)
+	}
+	if dbpool == nil {
+		return fmt.Errorf("query %q can not be prepared without a database connection", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryPrepareTimeout)
+	defer cancel()
+	query, err := modpostgres.PrepareQuery(ctx, dbpool, name, sql)
+	if err != nil {
+		return fmt.Errorf("query %q: %w", name, err)
+	}
+
+	if cfg.queries == nil {
+		cfg.queries = make(modpostgres.Queries)
+	}
+	cfg.queries[name] = query
+	return nil
+}
+
+func (cfg *Config) AddQuery(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, sql string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "sql", &sql); err != nil {
+		return starlark.None, err
+	}
+
+	if err := cfg.addQuery(name, sql); err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	return starlark.None, nil
+}
+
+// AddQueriesFromDir adds every .sql file below a directory relative to the
+// configuration, queries are named after their path without the extension
+func (cfg *Config) AddQueriesFromDir(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var dir string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "dir", &dir); err != nil {
+		return starlark.None, err
+	}
+
+	root := filepath.Join(cfg.rootdir, dir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".sql" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		sql, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(strings.TrimSuffix(rel, ".sql"))
+		return cfg.addQuery(name, string(sql))
+	})
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	return starlark.None, nil
+}
@@ -0,0 +1,71 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+)
+
+type replicaPool struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+var replica atomic.Pointer[replicaPool]
+
+// SetReplicaPool configures a read replica for read-only routes, the replica
+// is health checked until ctx is cancelled and unhealthy replicas are skipped
+func SetReplicaPool(ctx context.Context, pool *pgxpool.Pool) {
+	rp := &replicaPool{pool: pool}
+	rp.healthy.Store(true)
+	replica.Store(rp)
+	go rp.healthCheck(ctx)
+}
+
+func (rp *replicaPool) healthCheck(ctx context.Context) {
+	ticker := time.NewTicker(replicaHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, replicaHealthCheckTimeout)
+		err := rp.pool.Ping(pingCtx)
+		cancel()
+
+		healthy := err == nil
+		if rp.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Printf("read replica is healthy again, read-only routes use the replica")
+			} else {
+				log.Printf("read replica failed health check, read-only routes fall back to the primary: %s", err)
+			}
+		}
+	}
+}
+
+// WithReplica runs the postgres module of read-only routes on the read replica
+type WithReplica struct{}
+
+func (opt *WithReplica) Apply(thread *executor.ManagedThread) error {
+	rp := replica.Load()
+	if rp == nil || !rp.healthy.Load() {
+		return nil
+	}
+
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying the replica")
+	}
+	return loader.SetState(modpostgres.StateNameReplicaPool, rp.pool)
+}
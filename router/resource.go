@@ -0,0 +1,220 @@
+package router
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/protosam/pgstar/executor"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+//go:embed resource.star
+var resourceScript []byte
+
+// resourceActions are the routes registered for every resource
+var resourceActions = []struct {
+	action  string
+	methods []string
+	item    bool
+}{
+	{"list", []string{"GET"}, false},
+	{"create", []string{"POST"}, false},
+	{"get", []string{"GET"}, true},
+	{"update", []string{"PUT", "PATCH"}, true},
+	{"delete", []string{"DELETE"}, true},
+}
+
+type resourceColumn struct {
+	Name      string
+	Type      string
+	Generated bool
+	Primary   bool
+}
+
+// WithResource runs the built in resource script for a route of addResource()
+type WithResource struct {
+	Resource starlark.Value
+}
+
+func (opt *WithResource) Apply(thread *executor.ManagedThread) error {
+	thread.SetSource(resourceScript)
+	thread.Predeclare("resource", opt.Resource)
+	return nil
+}
+
+// introspectTable reads the columns and primary key of a table
+func introspectTable(ctx context.Context, table string) ([]resourceColumn, error) {
+	if dbpool == nil {
+		return nil, fmt.Errorf("table %s can not be introspected without a database connection", table)
+	}
+
+	rows, err := dbpool.Query(ctx, `
+SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attgenerated <> '' OR a.attidentity = 'a',
+	EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = a.attrelid AND i.indisprimary AND a.attnum = ANY(i.indkey))
+FROM pg_attribute a
+WHERE a.attrelid = to_regclass($1) AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`, table)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (resourceColumn, error) {
+		var column resourceColumn
+		err := row.Scan(&column.Name, &column.Type, &column.Generated, &column.Primary)
+		return column, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+
+	return columns, nil
+}
+
+func (cfg *Config) AddResource(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path, table, key, hooks string
+	var slcolumns, slreadonly *starlark.List
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "path", &path, "table", &table,
+		"columns?", &slcolumns, "readonly?", &slreadonly, "key?", &key, "hooks?", &hooks); err != nil {
+		return starlark.None, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryPrepareTimeout)
+	defer cancel()
+	columns, err := introspectTable(ctx, table)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	byName := map[string]resourceColumn{}
+	var primary []string
+	for _, column := range columns {
+		byName[column.Name] = column
+		if column.Primary {
+			primary = append(primary, column.Name)
+		}
+	}
+
+	toStrings := func(name string, list *starlark.List) ([]string, error) {
+		var values []string
+		if list == nil {
+			return values, nil
+		}
+		for i := 0; i < list.Len(); i++ {
+			value, ok := starlark.AsString(list.Index(i))
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", name)
+			}
+			if _, ok := byName[value]; !ok {
+				return nil, fmt.Errorf("%s: column %s does not exist in %s", name, value, table)
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	exposed, err := toStrings("columns", slcolumns)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+	readonly, err := toStrings("readonly", slreadonly)
+	if err != nil {
+		return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	// the key defaults to a single column primary key
+	if key == "" {
+		if len(primary) != 1 {
+			return starlark.None, fmt.Errorf("%s: %s needs a single column primary key or the key argument", fn.Name(), table)
+		}
+		key = primary[0]
+	}
+	if _, ok := byName[key]; !ok {
+		return starlark.None, fmt.Errorf("%s: key column %s does not exist in %s", fn.Name(), key, table)
+	}
+
+	// every column is exposed unless columns are listed, the key always is
+	if len(exposed) == 0 {
+		for _, column := range columns {
+			exposed = append(exposed, column.Name)
+		}
+	} else if !slices.Contains(exposed, key) {
+		exposed = append([]string{key}, exposed...)
+	}
+
+	slcols := starlark.NewDict(len(exposed))
+	quoted := make([]string, 0, len(exposed))
+	writable := starlark.NewList(nil)
+	var writableNames []string
+	for _, name := range exposed {
+		column := byName[name]
+		slcols.SetKey(starlark.String(name), starlarkstruct.FromStringDict(starlark.String("resource.column"), starlark.StringDict{
+			"quoted": starlark.String(pgx.Identifier{name}.Sanitize()),
+			"type":   starlark.String(column.Type),
+		}))
+		quoted = append(quoted, pgx.Identifier{name}.Sanitize())
+		if !column.Generated && !slices.Contains(readonly, name) {
+			writable.Append(starlark.String(name))
+			writableNames = append(writableNames, name)
+		}
+	}
+
+	loadHooks := func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		fns := starlark.StringDict{"before": starlark.None, "after": starlark.None}
+		if hooks != "" {
+			exports, err := thread.Load(thread, hooks)
+			if err != nil {
+				return starlark.None, err
+			}
+			for name := range fns {
+				if hook, ok := exports[name]; ok {
+					fns[name] = hook
+				}
+			}
+		}
+		return starlarkstruct.FromStringDict(starlark.String("resource.hooks"), fns), nil
+	}
+
+	for _, ra := range resourceActions {
+		routePath := strings.TrimSuffix(path, "/")
+		if ra.item {
+			routePath += "/{key}"
+		} else if routePath == "" {
+			routePath = "/"
+		}
+
+		slresource := starlarkstruct.FromStringDict(starlark.String("resource"), starlark.StringDict{
+			"action":       starlark.String(ra.action),
+			"table":        starlark.String(pgx.Identifier(strings.Split(table, ".")).Sanitize()),
+			"columns":      slcols,
+			"selectList":   starlark.String(strings.Join(quoted, ", ")),
+			"writable":     writable,
+			"defaultLimit": starlark.MakeInt(resourceDefaultLimit),
+			"maxLimit":     starlark.MakeInt(resourceMaxLimit),
+			"hooks":        starlark.NewBuiltin("resource.hooks", loadHooks),
+			"key": starlarkstruct.FromStringDict(starlark.String("resource.key"), starlark.StringDict{
+				"name":   starlark.String(key),
+				"quoted": starlark.String(pgx.Identifier{key}.Sanitize()),
+				"type":   starlark.String(byName[key].Type),
+			}),
+		})
+		// scripts of concurrent requests share the struct
+		slresource.Freeze()
+
+		cfg.routes = append(cfg.routes, route{
+			Methods: ra.methods,
+			Path:    routePath,
+			Script:  fmt.Sprintf("resource:%s:%s", table, ra.action),
+			Options: []WithOption{&WithResource{Resource: slresource}},
+			Meta:    resourceMeta(table, ra.action, key, columns, exposed, writableNames),
+		})
+	}
+
+	return starlark.None, nil
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseBuffer holds a response in memory until it is known the script's
+// transaction has been committed. Streamed responses can not wait for the
+// commit, the first Flush sends what is buffered and later writes go straight
+// to the client.
+type responseBuffer struct {
+	w          http.ResponseWriter
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+	streaming  bool
+}
+
+func newResponseBuffer(w http.ResponseWriter) *responseBuffer {
+	return &responseBuffer{w: w, header: http.Header{}}
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	if rb.streaming {
+		return rb.w.Header()
+	}
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(statusCode int) {
+	if rb.statusCode == 0 {
+		rb.statusCode = statusCode
+	}
+}
+
+func (rb *responseBuffer) Write(data []byte) (int, error) {
+	if rb.statusCode == 0 {
+		rb.statusCode = http.StatusOK
+	}
+	if rb.streaming {
+		return rb.w.Write(data)
+	}
+	return rb.body.Write(data)
+}
+
+// Flush switches to streaming, the response is sent before the transaction ends
+func (rb *responseBuffer) Flush() {
+	if !rb.streaming {
+		rb.flush()
+		rb.streaming = true
+	}
+	if flusher, ok := rb.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// flush copies the buffered response to the real response writer
+func (rb *responseBuffer) flush() {
+	if rb.streaming {
+		return
+	}
+	for key, values := range rb.header {
+		rb.w.Header()[key] = values
+	}
+	if rb.statusCode != 0 {
+		rb.w.WriteHeader(rb.statusCode)
+	}
+	rb.w.Write(rb.body.Bytes())
+	rb.body.Reset()
+}
+
+// discard replaces the buffered response with an error status, a streamed
+// response has already been sent and is left incomplete instead
+func (rb *responseBuffer) discard(statusCode int) {
+	if rb.streaming {
+		return
+	}
+	rb.w.Header().Set("Content-Type", "application/json")
+	rb.w.WriteHeader(statusCode)
+}
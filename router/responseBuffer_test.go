@@ -0,0 +1,75 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseBufferHoldsResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	rb := newResponseBuffer(w)
+	rb.Header().Set("Content-Type", "application/json")
+	rb.WriteHeader(http.StatusCreated)
+	rb.WriteHeader(http.StatusTeapot)
+	rb.Write([]byte(`{"id":1}`))
+
+	if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Fatalf("response was written before flush")
+	}
+
+	rb.flush()
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if got := w.Body.String(); got != `{"id":1}` {
+		t.Errorf("body = %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type = %q", got)
+	}
+}
+
+func TestResponseBufferDiscard(t *testing.T) {
+	w := httptest.NewRecorder()
+	rb := newResponseBuffer(w)
+	rb.WriteHeader(http.StatusOK)
+	rb.Write([]byte(`"committed?"`))
+
+	rb.discard(http.StatusInternalServerError)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("buffered body was sent: %q", w.Body.String())
+	}
+}
+
+func TestResponseBufferStreaming(t *testing.T) {
+	w := httptest.NewRecorder()
+	rb := newResponseBuffer(w)
+	rb.Header().Set("Content-Type", "application/x-ndjson")
+	rb.WriteHeader(http.StatusOK)
+	rb.Write([]byte("1\n"))
+
+	var flusher http.Flusher = rb
+	flusher.Flush()
+	if !rb.streaming || !w.Flushed {
+		t.Fatalf("flush did not start streaming")
+	}
+	if got := w.Body.String(); got != "1\n" {
+		t.Errorf("body after flush = %q", got)
+	}
+
+	rb.Write([]byte("2\n"))
+	if got := w.Body.String(); got != "1\n2\n" {
+		t.Errorf("body while streaming = %q", got)
+	}
+
+	// the streamed response can not be replaced or sent twice
+	rb.discard(http.StatusInternalServerError)
+	rb.flush()
+	if w.Code != http.StatusOK || w.Body.String() != "1\n2\n" {
+		t.Errorf("streamed response changed to %d %q", w.Code, w.Body.String())
+	}
+}
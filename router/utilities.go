@@ -1,9 +1,12 @@
 package router
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"time"
@@ -16,42 +19,155 @@ import (
 	"go.starlark.net/starlark"
 )
 
+// RetryPolicy configures how many times a script is executed again when its
+// transaction fails with a serialization failure or deadlock
+type RetryPolicy struct {
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// delay returns the jittered exponential backoff for a retry attempt
+func (policy RetryPolicy) delay(attempt int) time.Duration {
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for i := 0; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
 // SetDBPool updates the dbpool pointer
 func SetDBPool(pool *pgxpool.Pool) {
 	dbpool = pool
 }
 
-// WithStarlarkHandler returns an http handler function that runs a starlark script
+// WithStarlarkHandler returns an http handler function that runs a starlark
+// script, the response is buffered and only written once the transaction has
+// been committed so a failed commit is reported as an error
 func WithStarlarkHandler(rootdir, starfile string, globals map[string]starlark.Value, opts ...WithOption) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-
-		thread := executor.NewManagedThread(rootdir, starfile)
-		moduleloader := executor.NewModuleLoader(thread, thread.GetRootdir(), thread.GetStarfile())
-		moduleloader.SetState(modpostgres.StateNameDBPool, dbpool)
-		moduleloader.SetState(modhttp.StateNameReader, r)
-		moduleloader.SetState(modhttp.StateNameWriter, &w)
-		thread.SetModuleLoader(moduleloader)
+		rb := newResponseBuffer(w)
+		rb.Header().Set("Content-Type", "application/json")
 
-		for name, value := range globals {
-			thread.Predeclare(name, value)
+		if err := runStarlarkScript(rb, r, rootdir, starfile, globals, opts...); err != nil {
+			rb.discard(errorStatus(err))
+			return
 		}
+		rb.flush()
+	}
+}
 
-		for i := range opts {
-			opts[i].Apply(thread)
+// WithRetryingStarlarkHandler returns an http handler function that runs a
+// starlark script and runs it again on a fresh transaction when the
+// transaction fails with a retryable error, the response is buffered and only
+// written once an attempt has finished. Streamed responses have already been
+// sent and are never retried.
+func WithRetryingStarlarkHandler(policy RetryPolicy, rootdir, starfile string, globals map[string]starlark.Value, opts ...WithOption) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// every attempt needs to be able to read the request body
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				log.Printf("%s: error: failed to read request body: %s", starfile, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
 		}
 
-		defer moduleloader.Destroy()
-		if _, err := thread.Exec(); err != nil {
-			if !errors.Is(err, modules.ErrEarlyExit) {
-				log.Printf("%s: error: %s", thread.Name, err)
-				w.WriteHeader(http.StatusInternalServerError)
+		for attempt := 0; ; attempt++ {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			rb := newResponseBuffer(w)
+			rb.Header().Set("Content-Type", "application/json")
+
+			err := runStarlarkScript(rb, r, rootdir, starfile, globals, opts...)
+			if errors.Is(err, modules.ErrRetryable) && attempt < policy.Retries && !rb.streaming {
+				delay := policy.delay(attempt)
+				log.Printf("%s: retrying in %s after attempt %d: %s", starfile, delay, attempt+1, err)
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(delay):
+				}
+				continue
+			}
+
+			// the buffered response can not be trusted if the script or its
+			// transaction failed
+			if err != nil {
+				rb.discard(errorStatus(err))
 				return
 			}
+
+			rb.flush()
+			return
 		}
 	}
 }
 
+// errScriptPanicked is the outcome of a script that never returned from Exec,
+// modules are still destroyed so transactions and connections are released
+var errScriptPanicked = errors.New("script panicked")
+
+// errorStatus returns the status of a response for a failed script
+func errorStatus(err error) int {
+	if errors.Is(err, modules.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// runStarlarkScript executes a script for a request and returns the error
+// of the script, joined with any error encountered while finalizing the
+// loaded modules. An early exit is not an error.
+func runStarlarkScript(w http.ResponseWriter, r *http.Request, rootdir, starfile string, globals map[string]starlark.Value, opts ...WithOption) (scriptErr error) {
+	thread := executor.NewManagedThread(rootdir, starfile)
+	moduleloader := executor.NewModuleLoader(thread, thread.GetRootdir(), thread.GetStarfile())
+	moduleloader.SetState(modpostgres.StateNameDBPool, dbpool)
+	moduleloader.SetState(modhttp.StateNameReader, r)
+	moduleloader.SetState(modhttp.StateNameWriter, &w)
+	thread.SetModuleLoader(moduleloader)
+
+	for name, value := range globals {
+		thread.Predeclare(name, value)
+	}
+
+	for i := range opts {
+		opts[i].Apply(thread)
+	}
+
+	err := errScriptPanicked
+	defer func() {
+		// a client that went away cancels the script rather than failing it
+		if ctxErr := r.Context().Err(); ctxErr != nil && err != nil {
+			err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		destroyErr := moduleloader.Destroy(err)
+		if errors.Is(err, modules.ErrEarlyExit) {
+			err = nil
+		}
+		scriptErr = errors.Join(err, destroyErr)
+	}()
+
+	_, err = thread.Exec()
+	if err != nil && !errors.Is(err, modules.ErrEarlyExit) {
+		log.Printf("%s: error: %s", thread.Name, err)
+	}
+
+	return nil
+}
+
 func waitForFile(path string, timeout time.Duration) error {
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
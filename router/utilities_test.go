@@ -0,0 +1,90 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, dir, name, src string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithStarlarkHandler(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "created.star", `
+load("pgstar/http", http="exports")
+http.write(201, {"ok": True})
+`)
+	writeScript(t, dir, "fails.star", `
+load("pgstar/http", http="exports")
+fail("boom")
+`)
+	writeScript(t, dir, "stream_fails.star", `
+load("pgstar/http", http="exports")
+def m(row):
+    if row == 3:
+        fail("boom")
+    return row
+http.stream(200, [1, 2, 3, 4], fn=m)
+`)
+
+	tests := []struct {
+		script string
+		status int
+		body   string
+	}{
+		{"created.star", http.StatusCreated, `{"ok":true}`},
+		{"fails.star", http.StatusInternalServerError, ""},
+		// nothing was sent yet, so the status of the stream is replaced
+		{"stream_fails.star", http.StatusInternalServerError, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.script, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WithStarlarkHandler(dir, test.script, nil, WithNullPrinter())(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if got := w.Body.String(); got != test.body {
+				t.Errorf("body = %q, want %q", got, test.body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("content type = %q", got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+	for attempt, max := range []time.Duration{10, 20, 40, 40} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if delay := policy.delay(attempt); delay < max/2 || delay > max {
+				t.Fatalf("attempt %d: delay %s outside of [%s, %s]", attempt, delay, max/2, max)
+			}
+		}
+	}
+}
+
+func TestConfigureWithoutCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "config.star", `addRoute(["GET"], "/", "index.star")`)
+	writeScript(t, dir, "index.star", "")
+
+	// without a lockfile the package cache is never needed
+	t.Setenv("HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("PGSTAR_CACHE_DIR", "")
+	if _, err := Configure(filepath.Join(dir, "config.star")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,168 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/protosam/pgstar/executor/modules/modvalidate"
+	"go.starlark.net/starlark"
+)
+
+// routeValidation holds the schemas compiled for a route by addRoute()
+type routeValidation struct {
+	Body  *modvalidate.Validator
+	Query *modvalidate.Validator
+	Vars  *modvalidate.Validator
+}
+
+func (v routeValidation) enabled() bool {
+	return v.Body != nil || v.Query != nil || v.Vars != nil
+}
+
+// validationFailure is a failing value of a request
+type validationFailure struct {
+	Location string `json:"location"`
+	Pointer  string `json:"pointer"`
+	Message  string `json:"message"`
+}
+
+// compileSchema compiles a schema given as a dict or as a JSON file relative to the rootdir
+func (cfg *Config) compileSchema(name string, value starlark.Value) (*modvalidate.Validator, error) {
+	if value == nil || value == starlark.None {
+		return nil, nil
+	}
+
+	if path, ok := starlark.AsString(value); ok {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cfg.rootdir, path)
+		}
+		validator, err := modvalidate.CompileFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return validator, nil
+	}
+
+	if _, ok := value.(*starlark.Dict); !ok {
+		return nil, fmt.Errorf("%s must be a dict or a path to a JSON file", name)
+	}
+	document, err := modvalidate.ToJSONValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	validator, err := modvalidate.Compile(name, document)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return validator, nil
+}
+
+// bodylessMethods are not expected to have a body, the body schema of a route
+// only applies to them when a body is sent
+var bodylessMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// validate checks a request against the schemas of the route
+func (v routeValidation) validate(r *http.Request) []validationFailure {
+	var failures []validationFailure
+	add := func(location string, found []modvalidate.Failure) {
+		for _, failure := range found {
+			failures = append(failures, validationFailure{Location: location, Pointer: failure.Pointer, Message: failure.Message})
+		}
+	}
+
+	if v.Vars != nil {
+		vars := map[string][]string{}
+		for name, value := range mux.Vars(r) {
+			vars[name] = []string{value}
+		}
+		add("vars", v.Vars.Validate(v.Vars.Coerce(vars)))
+	}
+
+	if v.Query != nil {
+		add("query", v.Query.Validate(v.Query.Coerce(r.URL.Query())))
+	}
+
+	if v.Body != nil {
+		body, err := readBody(r)
+		if err != nil {
+			add("body", []modvalidate.Failure{{Message: "failed to read request body"}})
+			return failures
+		}
+		if len(body) == 0 && (bodylessMethods[r.Method] || r.Header.Get("Content-Type") == "") {
+			return failures
+		}
+
+		// content types are matched like http.post() does, a body without
+		// one is json and the header is set so the script reads it as json
+		contentType := r.Header.Get("Content-Type")
+		switch contentType {
+		case "", "application/json":
+			if contentType == "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			var decoded any
+			if err := decoder.Decode(&decoded); err != nil {
+				add("body", []modvalidate.Failure{{Message: "invalid json request"}})
+				break
+			}
+			add("body", v.Body.Validate(decoded))
+		case "application/x-www-form-urlencoded":
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				add("body", []modvalidate.Failure{{Message: "failed to parse form data"}})
+				break
+			}
+			add("body", v.Body.Validate(v.Body.Coerce(form)))
+		default:
+			add("body", []modvalidate.Failure{{Message: fmt.Sprintf("content type %s can not be validated", contentType)}})
+		}
+	}
+
+	return failures
+}
+
+// readBody reads the request body and replaces it so the script can read it again
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// withValidation rejects requests that do not match the schemas of a route
+// before the script runs, so no transaction is started for them
+func withValidation(validation routeValidation, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	if !validation.enabled() {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		failures := validation.validate(r)
+		if len(failures) == 0 {
+			handler(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":  "request validation failed",
+			"errors": failures,
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/protosam/pgstar/executor/modules/modvalidate"
+)
+
+func mustCompile(t *testing.T, document map[string]any) *modvalidate.Validator {
+	t.Helper()
+	validator, err := modvalidate.Compile(t.Name(), document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validator
+}
+
+func TestWithValidation(t *testing.T) {
+	validation := routeValidation{
+		Body: mustCompile(t, map[string]any{
+			"type":     "object",
+			"required": []any{"count"},
+			"properties": map[string]any{
+				"count": map[string]any{"type": "integer"},
+			},
+		}),
+		Query: mustCompile(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"limit": map[string]any{"type": "integer", "maximum": 100},
+			},
+		}),
+		Vars: mustCompile(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"id": map[string]any{"type": "integer"},
+			},
+		}),
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		failures    []string
+	}{
+		{"valid json", "POST", "/items/1", "application/json", `{"count": 2}`, nil},
+		{"missing content type is json", "POST", "/items/1", "", `{"count": 2}`, nil},
+		{"valid form", "POST", "/items/1", "application/x-www-form-urlencoded", "count=2", nil},
+		{"invalid json", "POST", "/items/1", "application/json", `{"count":`, []string{"body "}},
+		{"wrong body type", "POST", "/items/1", "application/json", `{"count": "two"}`, []string{"body /count"}},
+		{"wrong form type", "POST", "/items/1", "application/x-www-form-urlencoded", "count=two", []string{"body /count"}},
+		{"unsupported content type", "POST", "/items/1", "text/plain", "count", []string{"body "}},
+		{"query over maximum", "POST", "/items/1?limit=500", "application/json", `{"count": 2}`, []string{"query /limit"}},
+		{"get without a body", "GET", "/items/1", "", "", nil},
+		{"delete without a body", "DELETE", "/items/1", "application/json", "", nil},
+		{"post without a body or content type", "POST", "/items/1", "", "", nil},
+		{"post without a json body", "POST", "/items/1", "application/json", "", []string{"body "}},
+		{"get with a body", "GET", "/items/1", "application/json", `{"count": "two"}`, []string{"body /count"}},
+		{"vars not an integer", "POST", "/items/abc", "application/json", `{"count": 2}`, []string{"vars /id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var scriptBody, scriptContentType string
+			handler := withValidation(validation, func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				scriptBody, scriptContentType = string(data), r.Header.Get("Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+			})
+			router := mux.NewRouter()
+			router.HandleFunc("/items/{id}", handler)
+
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if tt.failures == nil {
+				if w.Code != http.StatusNoContent {
+					t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+				}
+				// the script reads the same body and content type that was validated
+				if scriptBody != tt.body {
+					t.Errorf("script body = %q, want %q", scriptBody, tt.body)
+				}
+				if tt.contentType == "" && tt.body != "" && scriptContentType != "application/json" {
+					t.Errorf("script content type = %q, want application/json", scriptContentType)
+				}
+				return
+			}
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			var response struct {
+				Errors []validationFailure `json:"errors"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			var failures []string
+			for _, failure := range response.Errors {
+				failures = append(failures, failure.Location+" "+failure.Pointer)
+			}
+			if strings.Join(failures, ",") != strings.Join(tt.failures, ",") {
+				t.Errorf("failures = %q, want %q", failures, tt.failures)
+			}
+		})
+	}
+}
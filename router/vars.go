@@ -8,3 +8,13 @@ import (
 
 var dbpool *pgxpool.Pool
 var configFileTimeout = 5 * time.Second
+var queryPrepareTimeout = 5 * time.Second
+
+var resourceDefaultLimit = 100
+var resourceMaxLimit = 1000
+
+var defaultRetryBackoff = 10 * time.Millisecond
+var defaultRetryMaxBackoff = time.Second
+var listenerReconnectDelay = 5 * time.Second
+var replicaHealthCheckInterval = 5 * time.Second
+var replicaHealthCheckTimeout = 2 * time.Second
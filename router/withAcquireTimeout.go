@@ -0,0 +1,23 @@
+package router
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+)
+
+// WithAcquireTimeout limits how long a script waits for a pool connection
+type WithAcquireTimeout struct {
+	Timeout time.Duration
+}
+
+func (opt *WithAcquireTimeout) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying acquire timeout")
+	}
+	timeout := opt.Timeout
+	return loader.SetState(modpostgres.StateNameAcquireTimeout, &timeout)
+}
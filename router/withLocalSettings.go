@@ -0,0 +1,55 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+	"github.com/protosam/pgstar/executor/modules/starutils"
+	"go.starlark.net/starlark"
+)
+
+// WithLocalSettings sets a role and settings on every transaction of a route
+// before the script runs its first statement
+type WithLocalSettings struct {
+	LocalSettings modpostgres.LocalSettings
+}
+
+func (opt *WithLocalSettings) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying local settings")
+	}
+	localSettings := opt.LocalSettings
+	return loader.SetState(modpostgres.StateNameLocalSettings, &localSettings)
+}
+
+// parseLocalSettings validates the role and settings passed to addRoute
+func parseLocalSettings(role string, settings *starlark.Dict) (*WithLocalSettings, error) {
+	opt := &WithLocalSettings{
+		LocalSettings: modpostgres.LocalSettings{
+			Role:     role,
+			Settings: map[string]string{},
+		},
+	}
+
+	for _, item := range settings.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("settings keys must be strings")
+		}
+
+		// non-string values are json encoded, e.g. claims for request.jwt.claims
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			encoded, err := starutils.StarlarkJsonEncoder(item[1])
+			if err != nil {
+				return nil, fmt.Errorf("setting %s: %w", key, err)
+			}
+			value = encoded
+		}
+		opt.LocalSettings.Settings[key] = value
+	}
+
+	return opt, nil
+}
@@ -0,0 +1,22 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/packages"
+)
+
+// WithPackages lets scripts load files of the packages in pgstar.lock
+type WithPackages struct {
+	Resolver *packages.Resolver
+}
+
+func (opt *WithPackages) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying packages")
+	}
+	loader.SetPackages(opt.Resolver)
+	return nil
+}
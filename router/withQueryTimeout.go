@@ -0,0 +1,22 @@
+package router
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+)
+
+type WithQueryTimeout struct {
+	Timeout time.Duration
+}
+
+func (opt *WithQueryTimeout) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying query timeout")
+	}
+	timeout := opt.Timeout
+	return loader.SetState(modpostgres.StateNameQueryTimeout, &timeout)
+}
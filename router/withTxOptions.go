@@ -0,0 +1,50 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/protosam/pgstar/executor"
+	"github.com/protosam/pgstar/executor/modules/modpostgres"
+)
+
+type WithTxOptions struct {
+	TxOptions pgx.TxOptions
+}
+
+func (opt *WithTxOptions) Apply(thread *executor.ManagedThread) error {
+	loader := thread.GetModuleLoader()
+	if loader == nil {
+		return fmt.Errorf("module loader must be set before applying transaction options")
+	}
+	txOptions := opt.TxOptions
+	return loader.SetState(modpostgres.StateNameTxOptions, &txOptions)
+}
+
+// parseTxOptions validates transaction options passed to addRoute
+func parseTxOptions(isolation, access string, deferrable bool) (*WithTxOptions, error) {
+	opt := &WithTxOptions{}
+	isolation = strings.ToLower(isolation)
+	access = strings.ToLower(access)
+
+	switch pgx.TxIsoLevel(isolation) {
+	case "", pgx.Serializable, pgx.RepeatableRead, pgx.ReadCommitted, pgx.ReadUncommitted:
+		opt.TxOptions.IsoLevel = pgx.TxIsoLevel(isolation)
+	default:
+		return nil, fmt.Errorf("invalid isolation level %q", isolation)
+	}
+
+	switch pgx.TxAccessMode(access) {
+	case "", pgx.ReadWrite, pgx.ReadOnly:
+		opt.TxOptions.AccessMode = pgx.TxAccessMode(access)
+	default:
+		return nil, fmt.Errorf("invalid access mode %q", access)
+	}
+
+	if deferrable {
+		opt.TxOptions.DeferrableMode = pgx.Deferrable
+	}
+
+	return opt, nil
+}
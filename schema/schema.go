@@ -0,0 +1,308 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/protosam/pgstar/migrations"
+)
+
+// Snapshot is a normalized description of the objects in a database, it is
+// ordered so two snapshots of the same schema encode identically
+type Snapshot struct {
+	Tables    []Table    `json:"tables"`
+	Functions []Function `json:"functions"`
+}
+
+type Table struct {
+	Schema      string       `json:"schema"`
+	Name        string       `json:"name"`
+	Columns     []Column     `json:"columns"`
+	Constraints []Constraint `json:"constraints"`
+	Indexes     []Index      `json:"indexes"`
+}
+
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Default  string `json:"default,omitempty"`
+}
+
+type Constraint struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+type Index struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+type Function struct {
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+	Definition string `json:"definition"`
+}
+
+// userObject filters out the system schemas and objects owned by extensions,
+// those are created by CREATE EXTENSION and not by the schema
+func userObject(oid string) string {
+	return `n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg\_toast%'
+	AND n.nspname NOT LIKE 'pg\_temp%'
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = ` + oid + ` AND d.deptype = 'e')`
+}
+
+// Dump reads a snapshot of the tables, columns, constraints, indexes and
+// functions from the catalog, tables named in exclude are skipped
+func Dump(ctx context.Context, conn *pgx.Conn, exclude ...string) (*Snapshot, error) {
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	snapshot := &Snapshot{Tables: []Table{}, Functions: []Function{}}
+	tables := map[uint32]*Table{}
+
+	rows, err := conn.Query(ctx, `
+SELECT c.oid, n.nspname, c.relname
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p') AND `+userObject("c.oid")+`
+ORDER BY n.nspname, c.relname`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tables: %w", err)
+	}
+	defer rows.Close()
+	var oids []uint32
+	for rows.Next() {
+		var oid uint32
+		var table Table
+		if err := rows.Scan(&oid, &table.Schema, &table.Name); err != nil {
+			return nil, err
+		}
+		if excluded[table.Name] || excluded[table.Schema+"."+table.Name] {
+			continue
+		}
+		table.Columns, table.Constraints, table.Indexes = []Column{}, []Constraint{}, []Index{}
+		snapshot.Tables = append(snapshot.Tables, table)
+		oids = append(oids, oid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read tables: %w", err)
+	}
+	for i, oid := range oids {
+		tables[oid] = &snapshot.Tables[i]
+	}
+
+	// columns keep their position since it is visible to SELECT *
+	rows, err = conn.Query(ctx, `
+SELECT a.attrelid, a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull, coalesce(pg_get_expr(ad.adbin, ad.adrelid), '')
+FROM pg_attribute a LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+WHERE a.attrelid = ANY($1) AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attrelid, a.attnum`, oids)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read columns: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid uint32
+		var column Column
+		if err := rows.Scan(&oid, &column.Name, &column.Type, &column.Nullable, &column.Default); err != nil {
+			return nil, err
+		}
+		tables[oid].Columns = append(tables[oid].Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read columns: %w", err)
+	}
+
+	rows, err = conn.Query(ctx, `
+SELECT conrelid, conname, pg_get_constraintdef(oid)
+FROM pg_constraint
+WHERE conrelid = ANY($1)
+ORDER BY conrelid, conname`, oids)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read constraints: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid uint32
+		var constraint Constraint
+		if err := rows.Scan(&oid, &constraint.Name, &constraint.Definition); err != nil {
+			return nil, err
+		}
+		tables[oid].Constraints = append(tables[oid].Constraints, constraint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read constraints: %w", err)
+	}
+
+	rows, err = conn.Query(ctx, `
+SELECT i.indrelid, c.relname, pg_get_indexdef(i.indexrelid)
+FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+WHERE i.indrelid = ANY($1)
+ORDER BY i.indrelid, c.relname`, oids)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read indexes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid uint32
+		var index Index
+		if err := rows.Scan(&oid, &index.Name, &index.Definition); err != nil {
+			return nil, err
+		}
+		tables[oid].Indexes = append(tables[oid].Indexes, index)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read indexes: %w", err)
+	}
+
+	rows, err = conn.Query(ctx, `
+SELECT n.nspname, p.proname, pg_get_function_identity_arguments(p.oid), pg_get_functiondef(p.oid)
+FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE p.prokind IN ('f', 'p') AND `+userObject("p.oid")+`
+ORDER BY n.nspname, p.proname, 3`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read functions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var function Function
+		if err := rows.Scan(&function.Schema, &function.Name, &function.Arguments, &function.Definition); err != nil {
+			return nil, err
+		}
+		snapshot.Functions = append(snapshot.Functions, function)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read functions: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// Encode writes the snapshot as indented JSON so it diffs well in version control
+func (snapshot *Snapshot) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Decode reads a snapshot written by Encode
+func Decode(data []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// objects flattens the snapshot into object names and their definitions
+func (snapshot *Snapshot) objects() map[string]string {
+	objects := map[string]string{}
+	for _, table := range snapshot.Tables {
+		name := table.Schema + "." + table.Name
+		objects["table "+name] = ""
+		for i, column := range table.Columns {
+			definition := fmt.Sprintf("#%d %s", i+1, column.Type)
+			if !column.Nullable {
+				definition += " NOT NULL"
+			}
+			if column.Default != "" {
+				definition += " DEFAULT " + column.Default
+			}
+			objects["column "+name+"."+column.Name] = definition
+		}
+		for _, constraint := range table.Constraints {
+			objects["constraint "+name+"."+constraint.Name] = constraint.Definition
+		}
+		for _, index := range table.Indexes {
+			objects["index "+table.Schema+"."+index.Name] = index.Definition
+		}
+	}
+	for _, function := range snapshot.Functions {
+		objects["function "+function.Schema+"."+function.Name+"("+function.Arguments+")"] = function.Definition
+	}
+	return objects
+}
+
+// Drift is a difference between the expected and the live schema
+type Drift struct {
+	Object   string
+	Expected string
+	Actual   string
+	Missing  bool
+	Extra    bool
+}
+
+func (d Drift) String() string {
+	switch {
+	case d.Missing:
+		return "missing " + d.Object
+	case d.Extra:
+		return "unexpected " + d.Object
+	}
+	return fmt.Sprintf("changed %s\n  expected: %s\n  actual:   %s", d.Object, d.Expected, d.Actual)
+}
+
+// Diff lists the objects that differ between the expected and actual
+// snapshots ordered by object name
+func Diff(expected, actual *Snapshot) []Drift {
+	want, got := expected.objects(), actual.objects()
+
+	var drift []Drift
+	for object, definition := range want {
+		actualDefinition, ok := got[object]
+		switch {
+		case !ok:
+			drift = append(drift, Drift{Object: object, Expected: definition, Missing: true})
+		case actualDefinition != definition:
+			drift = append(drift, Drift{Object: object, Expected: definition, Actual: actualDefinition})
+		}
+	}
+	for object, definition := range got {
+		if _, ok := want[object]; !ok {
+			drift = append(drift, Drift{Object: object, Actual: definition, Extra: true})
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Object < drift[j].Object })
+
+	return drift
+}
+
+// FromMigrations applies migrations to a temporary database created on the
+// same server as conn and returns its snapshot, the role needs CREATEDB
+func FromMigrations(ctx context.Context, conn *pgx.Conn, list []*migrations.Migration, table string) (*Snapshot, error) {
+	if table == "" {
+		table = migrations.DefaultTable
+	}
+
+	name := fmt.Sprintf("pgstar_shadow_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return nil, fmt.Errorf("unable to create shadow database: %w", err)
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "DROP DATABASE IF EXISTS "+pgx.Identifier{name}.Sanitize())
+
+	config := conn.Config().Copy()
+	config.Database = name
+	shadow, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to shadow database: %w", err)
+	}
+	defer shadow.Close(context.WithoutCancel(ctx))
+
+	migrator := &migrations.Migrator{Conn: shadow, Table: table}
+	if _, err := migrator.Up(ctx, list, 0); err != nil {
+		return nil, err
+	}
+
+	return Dump(ctx, shadow, table)
+}
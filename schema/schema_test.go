@@ -0,0 +1,114 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func snapshot(tables ...Table) *Snapshot {
+	return &Snapshot{Tables: tables, Functions: []Function{}}
+}
+
+func users(columns ...Column) Table {
+	return Table{
+		Schema:      "public",
+		Name:        "users",
+		Columns:     columns,
+		Constraints: []Constraint{{Name: "users_pkey", Definition: "PRIMARY KEY (id)"}},
+		Indexes:     []Index{{Name: "users_pkey", Definition: "CREATE UNIQUE INDEX users_pkey ON public.users USING btree (id)"}},
+	}
+}
+
+var (
+	id    = Column{Name: "id", Type: "bigint"}
+	email = Column{Name: "email", Type: "text", Nullable: true}
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected *Snapshot
+		actual   *Snapshot
+		want     []string
+	}{
+		{
+			name:     "identical",
+			expected: snapshot(users(id, email)),
+			actual:   snapshot(users(id, email)),
+			want:     nil,
+		},
+		{
+			name:     "added column",
+			expected: snapshot(users(id)),
+			actual:   snapshot(users(id, email)),
+			want:     []string{"unexpected column public.users.email"},
+		},
+		{
+			name:     "dropped column",
+			expected: snapshot(users(id, email)),
+			actual:   snapshot(users(id)),
+			want:     []string{"missing column public.users.email"},
+		},
+		{
+			name:     "altered column",
+			expected: snapshot(users(id, email)),
+			actual:   snapshot(users(id, Column{Name: "email", Type: "text"})),
+			want:     []string{"changed column public.users.email\n  expected: #2 text\n  actual:   #2 text NOT NULL"},
+		},
+		{
+			name:     "reordered columns",
+			expected: snapshot(users(id, email)),
+			actual:   snapshot(users(email, id)),
+			want: []string{
+				"changed column public.users.email\n  expected: #2 text\n  actual:   #1 text",
+				"changed column public.users.id\n  expected: #1 bigint NOT NULL\n  actual:   #2 bigint NOT NULL",
+			},
+		},
+		{
+			name:     "dropped table",
+			expected: snapshot(users(id)),
+			actual:   snapshot(),
+			want: []string{
+				"missing column public.users.id",
+				"missing constraint public.users.users_pkey",
+				"missing index public.users_pkey",
+				"missing table public.users",
+			},
+		},
+		{
+			name:     "added function",
+			expected: snapshot(),
+			actual: &Snapshot{Functions: []Function{{
+				Schema: "public", Name: "now_utc", Arguments: "", Definition: "CREATE FUNCTION public.now_utc()",
+			}}},
+			want: []string{"unexpected function public.now_utc()"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, drift := range Diff(tt.expected, tt.actual) {
+				got = append(got, drift.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	expected := snapshot(users(id, email))
+	data, err := expected.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift := Diff(expected, decoded); len(drift) != 0 {
+		t.Errorf("decoded snapshot differs: %v", drift)
+	}
+}